@@ -0,0 +1,148 @@
+// Package implements ANONYMOUS mechanism described in RFC 4505
+package anonymous
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Maximum length of trace information in characters
+const MAX_TRACE_LENGTH = 255
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:     "ANONYMOUS",
+		Explicit: true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			// There is no identity in ANONYMOUS, so user name is sent as trace information
+			return NewClient(cfg.Username), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			return NewServer(), nil
+		},
+	})
+}
+
+type Client struct {
+	trace string
+	step  int
+}
+
+// Creates client sending optional trace information, e.g. email address
+func NewClient(trace string) *Client {
+	return &Client{trace: trace}
+}
+
+// Generates client's message containing trace information
+func (c *Client) Response() ([]byte, error) {
+	if err := ValidateTrace(c.trace); err != nil {
+		return nil, err
+	}
+	return []byte(c.trace), nil
+}
+
+// Implements sasl.ClientMechanism. Trace is sent as initial response or as reply to empty challenge
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		resp, err := c.Response()
+		if err != nil {
+			return nil, false, err
+		}
+		c.step++
+		return resp, false, nil
+	case 1:
+		c.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+// Called with trace information provided by client. Returned error aborts authentication
+type TraceHandler func(trace string) error
+
+type Server struct {
+	handler TraceHandler
+	trace   string
+	step    int
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Sets handler receiving trace information of every authenticated client, e.g. for auditing
+func (s *Server) SetTraceHandler(handler TraceHandler) {
+	s.handler = handler
+}
+
+// Returns trace information provided by client
+func (s *Server) Trace() string {
+	return s.trace
+}
+
+// Anonymous clients have no authorization identity, so empty string is returned
+func (s *Server) AuthID() string {
+	return ""
+}
+
+// Anonymous clients have no authentication identity, so empty string is returned
+func (s *Server) UserName() string {
+	return ""
+}
+
+// Implements sasl.ServerMechanism. Response is treated as trace information,
+// so empty response means client didn't provide any
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	if s.step != 0 {
+		return nil, false, WrongClientMessage("Authentication exchange is already completed")
+	}
+
+	trace := string(response)
+	if err := ValidateTrace(trace); err != nil {
+		return nil, false, err
+	}
+
+	if s.handler != nil {
+		if err := s.handler(trace); err != nil {
+			return nil, false, err
+		}
+	}
+
+	s.trace = trace
+	s.step++
+	return nil, true, nil
+}
+
+// Checks that trace is either empty, an email address or a token.
+// Trace should be valid UTF-8 of at most MAX_TRACE_LENGTH characters without control characters
+func ValidateTrace(trace string) error {
+	if !utf8.ValidString(trace) {
+		return WrongClientMessage("Trace is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(trace) > MAX_TRACE_LENGTH {
+		return WrongClientMessage("Trace is too long")
+	}
+
+	for _, r := range trace {
+		if unicode.IsControl(r) || unicode.Is(unicode.Co, r) || unicode.Is(unicode.Cs, r) {
+			return WrongClientMessage("Trace contains prohibited character")
+		}
+	}
+
+	if at := strings.IndexByte(trace, '@'); at >= 0 {
+		// Email form: addr-spec with non-empty local part and domain
+		local, domain := trace[:at], trace[at+1:]
+		if local == "" || domain == "" || strings.IndexByte(domain, '@') >= 0 {
+			return WrongClientMessage("Trace is neither email address nor token")
+		}
+	}
+	return nil
+}
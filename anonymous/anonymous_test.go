@@ -0,0 +1,74 @@
+package anonymous
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateTrace(t *testing.T) {
+	for _, trace := range []string{"", "sirhc", "abuse@example.com", "ελληνικά", strings.Repeat("ü", MAX_TRACE_LENGTH)} {
+		if err := ValidateTrace(trace); err != nil {
+			t.Fatalf("Trace %q should be valid: %v", trace, err)
+		}
+	}
+
+	for _, trace := range []string{"@example.com", "abuse@", "a@b@c", "bad\x00trace", "bad\ntrace", "\xff", strings.Repeat("x", MAX_TRACE_LENGTH+1)} {
+		if err := ValidateTrace(trace); err == nil {
+			t.Fatalf("Trace %q should be rejected", trace)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	var audited []string
+
+	c, s := NewClient("sirhc"), NewServer()
+	s.SetTraceHandler(func(trace string) error {
+		audited = append(audited, trace)
+		return nil
+	})
+
+	resp, done, err := c.Step(nil)
+	if err != nil || done {
+		t.Fatal("Client should send initial response", err)
+	}
+	if string(resp) != "sirhc" {
+		t.Fatalf("Wrong response generated: %q", resp)
+	}
+
+	if _, done, err := s.Step(resp); err != nil || !done {
+		t.Fatal("Authentication failed", err)
+	}
+	if _, done, err := c.Step(nil); err != nil || !done {
+		t.Fatal("Client should complete authentication", err)
+	}
+
+	if s.Trace() != "sirhc" || len(audited) != 1 || audited[0] != "sirhc" {
+		t.Fatal("Trace should be passed to handler:", audited)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	s := NewServer()
+	s.SetTraceHandler(func(trace string) error { return errors.New("Banned") })
+	if _, _, err := s.Step([]byte("spammer")); err == nil {
+		t.Fatal("Handler error should abort authentication")
+	}
+
+	if _, _, err := NewServer().Step([]byte("a@b@c")); err == nil {
+		t.Fatal("Malformed trace should be rejected")
+	}
+
+	if _, _, err := NewClient("bad\x00").Step(nil); err == nil {
+		t.Fatal("Client should not send malformed trace")
+	}
+}
+
+func TestUnexpectedChallenge(t *testing.T) {
+	if _, _, err := NewClient("trace").Step([]byte("challenge")); err == nil {
+		t.Fatal("Non-empty challenge should be rejected")
+	} else if _, ok := err.(WrongServerMessage); !ok {
+		t.Fatal("Server's message error expected:", err)
+	}
+}
@@ -0,0 +1,99 @@
+package sasl
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Channel binding types defined by RFC 5929 and RFC 9266
+const (
+	TLS_UNIQUE           = "tls-unique"
+	TLS_SERVER_END_POINT = "tls-server-end-point"
+	TLS_EXPORTER         = "tls-exporter"
+
+	// Label used to export keying material for tls-exporter
+	TLS_EXPORTER_LABEL = "EXPORTER-Channel-Binding"
+)
+
+// Channel binding data bound to cb-name it was obtained with
+type ChannelBinding struct {
+	Type string
+	Data []byte
+}
+
+type UnsupportedChannelBinding string
+
+func (ucb UnsupportedChannelBinding) Error() string {
+	return fmt.Sprintf("Channel binding is not available: %s", string(ucb))
+}
+
+// Extracts channel binding of requested type from TLS connection state.
+// tls-server-end-point is calculated from peer's certificate, so only
+// client can get it this way. Server should use ServerEndPointBinding instead
+func NewChannelBinding(cb_type string, state *tls.ConnectionState) (*ChannelBinding, error) {
+	if state == nil || !state.HandshakeComplete {
+		return nil, UnsupportedChannelBinding(cb_type)
+	}
+
+	switch cb_type {
+	case TLS_UNIQUE:
+		// tls-unique is not defined for TLS 1.3
+		if len(state.TLSUnique) == 0 {
+			return nil, UnsupportedChannelBinding(cb_type)
+		}
+		return &ChannelBinding{Type: cb_type, Data: MakeCopy(state.TLSUnique)}, nil
+	case TLS_SERVER_END_POINT:
+		if len(state.PeerCertificates) == 0 {
+			return nil, UnsupportedChannelBinding(cb_type)
+		}
+		return ServerEndPointBinding(state.PeerCertificates[0])
+	case TLS_EXPORTER:
+		data, err := state.ExportKeyingMaterial(TLS_EXPORTER_LABEL, nil, 32)
+		if err != nil {
+			return nil, UnsupportedChannelBinding(cb_type)
+		}
+		return &ChannelBinding{Type: cb_type, Data: data}, nil
+	}
+
+	return nil, UnsupportedChannelBinding(cb_type)
+}
+
+// Calculates tls-server-end-point channel binding from server's certificate
+// as described in RFC 5929 section 4.1
+func ServerEndPointBinding(cert *x509.Certificate) (*ChannelBinding, error) {
+	var hash crypto.Hash
+
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	default:
+		// Binding is undefined for signatures using no or multiple hash functions
+		return nil, UnsupportedChannelBinding(TLS_SERVER_END_POINT)
+	}
+
+	h := hash.New()
+	h.Write(cert.Raw)
+	return &ChannelBinding{Type: TLS_SERVER_END_POINT, Data: h.Sum(nil)}, nil
+}
+
+// Returns all channel bindings which could be extracted from TLS connection state
+// starting from the most preferable one
+func ChannelBindings(state *tls.ConnectionState) []*ChannelBinding {
+	var cbs []*ChannelBinding
+	for _, cb_type := range []string{TLS_EXPORTER, TLS_UNIQUE, TLS_SERVER_END_POINT} {
+		if cb, err := NewChannelBinding(cb_type, state); err == nil {
+			cbs = append(cbs, cb)
+		}
+	}
+	return cbs
+}
@@ -0,0 +1,116 @@
+package sasl_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goxmpp/sasl"
+)
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+// Performs TLS handshake over in-memory connection and returns both connection states
+func handshake(t *testing.T, cert tls.Certificate, version uint16) (*tls.ConnectionState, *tls.ConnectionState) {
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+	defer sconn.Close()
+
+	server := tls.Server(sconn, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: version, MaxVersion: version})
+	client := tls.Client(cconn, &tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	cstate, sstate := client.ConnectionState(), server.ConnectionState()
+	return &cstate, &sstate
+}
+
+func TestChannelBindings(t *testing.T) {
+	cert := selfSigned(t)
+
+	cstate, sstate := handshake(t, cert, tls.VersionTLS13)
+	ccb, err := sasl.NewChannelBinding(sasl.TLS_EXPORTER, cstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scb, err := sasl.NewChannelBinding(sasl.TLS_EXPORTER, sstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ccb.Data, scb.Data) {
+		t.Fatal("Client and server should get same tls-exporter binding")
+	}
+
+	if _, err := sasl.NewChannelBinding(sasl.TLS_UNIQUE, cstate); err == nil {
+		t.Fatal("tls-unique should not be available for TLS 1.3")
+	}
+
+	cstate, sstate = handshake(t, cert, tls.VersionTLS12)
+	ccb, err = sasl.NewChannelBinding(sasl.TLS_UNIQUE, cstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scb, err = sasl.NewChannelBinding(sasl.TLS_UNIQUE, sstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ccb.Data, scb.Data) {
+		t.Fatal("Client and server should get same tls-unique binding")
+	}
+
+	ccb, err = sasl.NewChannelBinding(sasl.TLS_SERVER_END_POINT, cstate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scb, err = sasl.ServerEndPointBinding(cert.Leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := sha256.Sum256(cert.Leaf.Raw)
+	if !bytes.Equal(ccb.Data, scb.Data) || !bytes.Equal(scb.Data, expect[:]) {
+		t.Fatal("Wrong tls-server-end-point binding calculated")
+	}
+
+	if cbs := sasl.ChannelBindings(cstate); len(cbs) == 0 || cbs[0].Type != sasl.TLS_EXPORTER {
+		t.Fatal("tls-exporter should be preferred binding")
+	}
+}
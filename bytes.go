@@ -2,6 +2,7 @@ package sasl
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -16,6 +17,9 @@ func BytesToHex(src []byte) []byte {
 func ExtractParameter(source []byte, param []byte) ([]byte, error) {
 	var pvalue []byte
 	err := EachToken(source, ',', func(token []byte) error {
+		if bytes.IndexByte(token, '=') < 0 {
+			return fmt.Errorf("Token does not contain key value pair: %s", token)
+		}
 		k, v := ExtractKeyValue(token, '=')
 
 		if bytes.Equal(k, param) {
@@ -53,13 +57,20 @@ func MakeMessage(kvs ...[]byte) []byte {
 }
 
 func EachField(mess []byte, predicate func([]byte) error) error {
-	in_quotes := false
+	in_quotes, escaped := false, false
 	pred := func(r rune) bool {
-		if r == '"' {
+		switch {
+		case escaped:
+			escaped = false
+		case in_quotes && r == '\\':
+			// Quoted pair, next character is a part of value
+			escaped = true
+		case r == '"':
 			in_quotes = !in_quotes
-			return false
+		default:
+			return r == ',' && !in_quotes
 		}
-		return r == ',' && !in_quotes
+		return false
 	}
 	for _, field := range bytes.FieldsFunc(mess, pred) {
 		if err := predicate(field); err != nil {
@@ -89,8 +100,12 @@ func Contains(find []byte, arr [][]byte) bool {
 	return false
 }
 
+// Splits token at the first separator. Value is nil if token has no separator
 func ExtractKeyValue(token []byte, sep byte) ([]byte, []byte) {
 	kv := bytes.SplitN(token, []byte{sep}, 2)
+	if len(kv) < 2 {
+		return kv[0], nil
+	}
 	return kv[0], kv[1]
 }
 
@@ -99,3 +114,9 @@ func Base64ToBytes(src []byte) []byte {
 	base64.StdEncoding.Encode(dest, src)
 	return dest
 }
+
+// Compares secret-derived values (proofs, signatures, digests) in constant time,
+// so comparison time doesn't leak how many leading bytes match
+func SecureEqual(left, right []byte) bool {
+	return subtle.ConstantTimeCompare(left, right) == 1
+}
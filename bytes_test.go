@@ -0,0 +1,39 @@
+package sasl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecureEqual(t *testing.T) {
+	if !SecureEqual([]byte("proof"), []byte("proof")) {
+		t.Fatal("Equal values should match")
+	}
+
+	if SecureEqual([]byte("proof"), []byte("prooF")) || SecureEqual([]byte("proof"), []byte("proo")) {
+		t.Fatal("Different values should not match")
+	}
+}
+
+func TestEachField(t *testing.T) {
+	var fields []string
+	EachField([]byte(`a="x,\"y\\",b=z`), func(field []byte) error {
+		fields = append(fields, string(field))
+		return nil
+	})
+	if expected := []string{`a="x,\"y\\"`, `b=z`}; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("Escaped quotes should not end quoted value, got %q", fields)
+	}
+}
+
+func TestExtractParameter(t *testing.T) {
+	if v, err := ExtractParameter([]byte("c=biws,r=abc"), []byte("r")); err != nil || string(v) != "abc" {
+		t.Fatal("Parameter should be extracted:", string(v), err)
+	}
+	if _, err := ExtractParameter([]byte("c=biws,garbage"), []byte("c")); err == nil {
+		t.Fatal("Token without value should be rejected")
+	}
+	if k, v := ExtractKeyValue([]byte("garbage"), '='); string(k) != "garbage" || v != nil {
+		t.Fatal("Token without separator should be returned as key:", string(k), v)
+	}
+}
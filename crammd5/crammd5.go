@@ -0,0 +1,240 @@
+// Package implements CRAM-MD5 mechanism described in RFC 2195.
+// Mechanism is considered obsolete and should be used only with legacy peers
+package crammd5
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/goxmpp/sasl"
+)
+
+const NONCE_BYTES = 12
+
+var DefaultGenerator sasl.Generator
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:     "CRAM-MD5",
+		HashBits: 128,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			return NewClient(cfg.Username, cfg.Password), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			s := NewServer(cfg.Host, cfg.Generator)
+			s.SetPasswordStore(cfg.Passwords)
+			// Stores able to provide precomputed secrets are preferred to passwords
+			if store, ok := cfg.Passwords.(SecretStore); ok {
+				s.SetSecretStore(store)
+			}
+			return s, nil
+		},
+	})
+}
+
+type Client struct {
+	username, password string
+	step               int
+}
+
+func NewClient(username, password string) *Client {
+	return &Client{username: username, password: password}
+}
+
+// Generates response to server's challenge
+func (c *Client) Response(challenge []byte) ([]byte, error) {
+	if len(challenge) == 0 {
+		return nil, WrongServerMessage("Empty challenge")
+	}
+
+	mac := hmac.New(md5.New, []byte(c.password))
+	mac.Write(challenge)
+
+	digest := make([]byte, hex.EncodedLen(md5.Size))
+	hex.Encode(digest, mac.Sum(nil))
+
+	return append(append([]byte(c.username), ' '), digest...), nil
+}
+
+// Implements sasl.ClientMechanism. CRAM-MD5 has no initial response, so empty challenge
+// of the first step is answered with empty response
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) == 0 {
+			return nil, false, nil
+		}
+		resp, err := c.Response(challenge)
+		if err != nil {
+			return nil, false, err
+		}
+		c.step++
+		return resp, false, nil
+	case 1:
+		c.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+type Server struct {
+	hostname  string
+	gen       sasl.NonceGenerator
+	store     sasl.PasswordStore
+	secrets   SecretStore
+	challenge []byte
+	username  string
+	digest    []byte
+	step      int
+	failed    bool
+}
+
+// Creates server using hostname in challenges.
+// nil can be provided as generator - then default generator will be used
+func NewServer(hostname string, gen sasl.NonceGenerator) *Server {
+	if gen == nil {
+		gen = DefaultGenerator
+	}
+	return &Server{hostname: hostname, gen: gen}
+}
+
+// Sets passwords store used to authenticate users with Step
+func (s *Server) SetPasswordStore(store sasl.PasswordStore) {
+	s.store = store
+}
+
+// Sets store of precomputed secrets used instead of passwords store
+func (s *Server) SetSecretStore(store SecretStore) {
+	s.secrets = store
+}
+
+// CRAM-MD5 doesn't support authorization identity, so UserName is returned
+func (s *Server) AuthID() string {
+	return s.username
+}
+
+// Returns UserName provided by client
+func (s *Server) UserName() string {
+	return s.username
+}
+
+// Generates (if necessary) and returns challenge in <random.timestamp@hostname> form
+func (s *Server) Challenge() ([]byte, error) {
+	if len(s.challenge) == 0 {
+		nonce, err := s.gen.GetNonce(NONCE_BYTES)
+		if err != nil {
+			return nil, err
+		}
+
+		chal := append([]byte{'<'}, nonce...)
+		chal = append(chal, '.')
+		chal = strconv.AppendInt(chal, time.Now().Unix(), 10)
+		chal = append(chal, '@')
+		chal = append(chal, s.hostname...)
+		s.challenge = append(chal, '>')
+	}
+	return sasl.MakeCopy(s.challenge), nil
+}
+
+// Parses client's response containing user name and hex encoded digest
+func (s *Server) ParseResponse(response []byte) error {
+	// User name may contain spaces, digest is always the last token
+	sp := bytes.LastIndexByte(response, ' ')
+	if sp <= 0 {
+		return WrongClientMessage("Response should contain user name and digest")
+	}
+
+	encoded := response[sp+1:]
+	if len(encoded) != hex.EncodedLen(md5.Size) || !bytes.Equal(encoded, bytes.ToLower(encoded)) {
+		return WrongClientMessage("Digest should be 32 lowercase hex digits")
+	}
+
+	digest := make([]byte, md5.Size)
+	if _, err := hex.Decode(digest, encoded); err != nil {
+		return WrongClientMessage("Digest should be 32 lowercase hex digits")
+	}
+
+	s.username, s.digest = string(response[:sp]), digest
+	return nil
+}
+
+// Checks client's digest against password
+func (s *Server) Validate(password string) error {
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write(s.challenge)
+	return s.check(mac.Sum(nil))
+}
+
+// Checks client's digest against precomputed secret
+func (s *Server) ValidateSecret(secret *Secret) error {
+	expected, err := secret.Digest(s.challenge)
+	if err != nil {
+		return err
+	}
+	return s.check(expected)
+}
+
+func (s *Server) check(expected []byte) error {
+	if !sasl.SecureEqual(expected, s.digest) {
+		return WrongClientMessage("Wrong digest provided")
+	}
+	return nil
+}
+
+// Implements sasl.ServerMechanism. SetPasswordStore or SetSecretStore should be called before this method usage.
+// Rejected response ends exchange since the same challenge must not be answered twice
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	if s.failed {
+		return nil, false, WrongClientMessage("Authentication has already failed")
+	}
+
+	switch s.step {
+	case 0:
+		chal, err := s.Challenge()
+		if err != nil {
+			return nil, false, err
+		}
+		s.step++
+		return chal, false, nil
+	case 1:
+		if err := s.ParseResponse(response); err != nil {
+			s.failed = true
+			return nil, false, err
+		}
+
+		if err := s.validate(); err != nil {
+			s.failed = true
+			return nil, false, err
+		}
+
+		s.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongClientMessage("Authentication exchange is already completed")
+}
+
+func (s *Server) validate() error {
+	if s.secrets != nil {
+		secret, err := s.secrets.Secret(s.username)
+		if err != nil {
+			return err
+		}
+		return s.ValidateSecret(secret)
+	}
+
+	if s.store == nil {
+		return errors.New("Password store is not set")
+	}
+	password, err := s.store.Password(s.username)
+	if err != nil {
+		return err
+	}
+	return s.Validate(password)
+}
@@ -0,0 +1,145 @@
+package crammd5
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	std_challenge = "<1896.697170952@postoffice.reston.mci.net>"
+	std_response  = "tim b913a602c7eda7a495b4e6e7334d3890"
+)
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	return p[username], nil
+}
+
+type secrets map[string]*Secret
+
+func (s secrets) Secret(username string) (*Secret, error) {
+	return s[username], nil
+}
+
+func TestStandardExample(t *testing.T) {
+	resp, err := NewClient("tim", "tanstaaftanstaaf").Response([]byte(std_challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != std_response {
+		t.Fatalf("Wrong response generated: %s", resp)
+	}
+
+	s := NewServer("postoffice.reston.mci.net", nil)
+	s.challenge = []byte(std_challenge)
+	if err := s.ParseResponse([]byte(std_response)); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserName() != "tim" {
+		t.Fatal("Wrong user name parsed:", s.UserName())
+	}
+	if err := s.Validate("tanstaaftanstaaf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateSecret(NewSecret("tanstaaftanstaaf")); err != nil {
+		t.Fatal("Precomputed secret should match password:", err)
+	}
+	if err := s.Validate("wrong"); err == nil {
+		t.Fatal("Wrong password should be rejected")
+	}
+}
+
+func TestSecretLongKey(t *testing.T) {
+	password := strings.Repeat("k", 100)
+	c := NewClient("tim", password)
+
+	s := NewServer("example.com", nil)
+	chal, err := s.Challenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Response(chal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateSecret(NewSecret(password)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	chal, err := NewServer("example.com", nil).Challenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chal[0] != '<' || !strings.HasSuffix(string(chal), "@example.com>") || !strings.Contains(string(chal), ".") {
+		t.Fatalf("Wrong challenge format: %s", chal)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	for _, resp := range []string{"tim", " b913a602c7eda7a495b4e6e7334d3890", "tim B913A602C7EDA7A495B4E6E7334D3890", "tim b913a602", "tim z913a602c7eda7a495b4e6e7334d3890"} {
+		if err := NewServer("example.com", nil).ParseResponse([]byte(resp)); err == nil {
+			t.Fatalf("Malformed response %q should be rejected", resp)
+		}
+	}
+
+	s := NewServer("example.com", nil)
+	if err := s.ParseResponse([]byte("tim smith b913a602c7eda7a495b4e6e7334d3890")); err != nil || s.UserName() != "tim smith" {
+		t.Fatal("User name with spaces should be accepted", err)
+	}
+}
+
+func TestStep(t *testing.T) {
+	for _, store := range []interface{}{passwords{"tim": "tanstaaftanstaaf"}, secrets{"tim": NewSecret("tanstaaftanstaaf")}} {
+		c, s := NewClient("tim", "tanstaaftanstaaf"), NewServer("example.com", nil)
+		if p, ok := store.(passwords); ok {
+			s.SetPasswordStore(p)
+		} else {
+			s.SetSecretStore(store.(secrets))
+		}
+
+		resp, _, err := c.Step(nil)
+		if err != nil || len(resp) != 0 {
+			t.Fatal("Client should not send initial response", err)
+		}
+
+		chal, _, err := s.Step(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp, _, err = c.Step(chal); err != nil {
+			t.Fatal(err)
+		}
+		if _, done, err := s.Step(resp); err != nil || !done {
+			t.Fatal("Authentication failed", err)
+		}
+		if _, done, err := c.Step(nil); err != nil || !done {
+			t.Fatal("Client should complete authentication", err)
+		}
+	}
+}
+
+func TestStepFailure(t *testing.T) {
+	c, s := NewClient("tim", "tanstaaftanstaaf"), NewServer("example.com", nil)
+	chal, _, err := s.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err := c.Step(chal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Step(resp); err == nil {
+		t.Fatal("Server without store should fail")
+	}
+
+	s.SetPasswordStore(passwords{"tim": "tanstaaftanstaaf"})
+	if _, _, err := s.Step(resp); err == nil {
+		t.Fatal("Failed exchange should not be continued")
+	}
+}
@@ -0,0 +1,19 @@
+package crammd5
+
+import "fmt"
+
+type WrongClientMessage string
+
+func (wcm WrongClientMessage) Error() string {
+	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
+}
+
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
+
+type WrongServerMessage string
+
+func (wsm WrongServerMessage) Error() string {
+	return fmt.Sprintf("Wrong Server Message Provided: %s", string(wsm))
+}
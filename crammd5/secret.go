@@ -0,0 +1,95 @@
+package crammd5
+
+import (
+	"crypto/md5"
+	"encoding"
+	"encoding/binary"
+	"hash"
+)
+
+const (
+	md5Magic     = "md5\x01"
+	md5BlockSize = 64
+)
+
+// Intermediate HMAC-MD5 results described in RFC 2195 section 2.
+// Inner and Outer are MD5 chaining values (as little-endian words) after
+// processing key XOR ipad and key XOR opad blocks. They allow server to verify
+// digests without storing password in plain text, but are password equivalent
+type Secret struct {
+	Inner [md5.Size]byte
+	Outer [md5.Size]byte
+}
+
+// Used by server to get precomputed secret of the user
+type SecretStore interface {
+	Secret(username string) (*Secret, error)
+}
+
+// Precomputes secret from password
+func NewSecret(password string) *Secret {
+	key := []byte(password)
+	if len(key) > md5BlockSize {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+
+	ipad, opad := make([]byte, md5BlockSize), make([]byte, md5BlockSize)
+	copy(ipad, key)
+	copy(opad, key)
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+
+	secret := &Secret{}
+	chainingValue(secret.Inner[:], ipad)
+	chainingValue(secret.Outer[:], opad)
+	return secret
+}
+
+// Calculates HMAC-MD5 of challenge using precomputed secret
+func (s *Secret) Digest(challenge []byte) ([]byte, error) {
+	inner, err := restore(s.Inner[:])
+	if err != nil {
+		return nil, err
+	}
+	inner.Write(challenge)
+
+	outer, err := restore(s.Outer[:])
+	if err != nil {
+		return nil, err
+	}
+	outer.Write(inner.Sum(nil))
+	return outer.Sum(nil), nil
+}
+
+// Writes MD5 chaining value after processing single block
+func chainingValue(dst, block []byte) {
+	h := md5.New()
+	h.Write(block)
+	state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+
+	// Marshaled state starts with magic followed by big-endian words
+	for i := 0; i < 4; i++ {
+		word := binary.BigEndian.Uint32(state[len(md5Magic)+i*4:])
+		binary.LittleEndian.PutUint32(dst[i*4:], word)
+	}
+}
+
+// Creates MD5 hash continuing from chaining value after single block
+func restore(cv []byte) (hash.Hash, error) {
+	state := make([]byte, 0, len(md5Magic)+md5.Size+md5BlockSize+8)
+	state = append(state, md5Magic...)
+	for i := 0; i < 4; i++ {
+		state = binary.BigEndian.AppendUint32(state, binary.LittleEndian.Uint32(cv[i*4:]))
+	}
+	state = append(state, make([]byte, md5BlockSize)...)
+	state = binary.BigEndian.AppendUint64(state, md5BlockSize)
+
+	h := md5.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -2,6 +2,9 @@ package digest
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/goxmpp/sasl"
 )
@@ -11,17 +14,19 @@ type challenge struct {
 	nonce   []byte
 	qop     [][]byte // auth or auth-int
 	charset []byte
-	algo    []byte // md5 or md5-sess, Default md5
+	algo    []byte // Only md5-sess is supported
 	stale   []byte // 'TRUE' or 'FALSE'
+	maxbuf  int    // Maximal size of security layer buffer server accepts, 0 means default
+	ciphers [][]byte
 }
 
-func newChallenge(opts *Options) *challenge {
-	algo, charset, realms, qops := "md5", "utf-8", [][]byte{}, [][]byte{[]byte("auth")}
-	if opts.Algorithm != "" {
-		algo = opts.Algorithm
+func newChallenge(opts *Options) (*challenge, error) {
+	algo, charset, realms, qops := ALGORITHM_MD5_SESS, "utf-8", [][]byte{}, [][]byte{[]byte("auth")}
+	if opts.Algorithm != "" && !strings.EqualFold(opts.Algorithm, ALGORITHM_MD5_SESS) {
+		return nil, fmt.Errorf("Unsupported algorithm: %s", opts.Algorithm)
 	}
 	if opts.Charset != "" {
-		algo = opts.Charset
+		charset = opts.Charset
 	}
 
 	if len(opts.QOPs) > 0 {
@@ -35,13 +40,29 @@ func newChallenge(opts *Options) *challenge {
 		realms = append(realms, []byte(realm))
 	}
 
-	return &challenge{
-		nonce:   opts.Generator.GetNonce(nonce_size),
+	nonce, err := opts.Generator.GetNonce(nonce_size)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &challenge{
+		nonce:   nonce,
 		algo:    []byte(algo),
 		qop:     qops,
 		charset: []byte(charset),
 		realms:  realms,
+		maxbuf:  opts.MaxBuf,
+	}
+	if sasl.Contains([]byte(QOP_AUTH_CONF), qops) {
+		ciphers := opts.Ciphers
+		if len(ciphers) == 0 {
+			ciphers = Ciphers
+		}
+		for _, cipher := range ciphers {
+			c.ciphers = append(c.ciphers, []byte(cipher))
+		}
 	}
+	return c, nil
 }
 
 func (c *challenge) Realms() []string {
@@ -68,6 +89,10 @@ func (c *challenge) challenge() []byte {
 	challenge = appendKVQuoted(challenge, "qop", sasl.MakeMessage(c.qop...))
 	challenge = appendKVQuoted(challenge, "stale", c.stale)
 	challenge = appendKV(challenge, "charset", c.charset)
+	if c.maxbuf > 0 {
+		challenge = appendKV(challenge, "maxbuf", []byte(strconv.Itoa(c.maxbuf)))
+	}
+	challenge = appendKVQuoted(challenge, "cipher", sasl.MakeMessage(c.ciphers...))
 
 	return sasl.MakeMessage(challenge...)
 }
@@ -80,14 +105,25 @@ func (c *challenge) parseChallenge(challenge []byte) error {
 	fmap.Add("stale", &(c.stale))
 
 	return sasl.EachField(challenge, func(field []byte) error {
+		if !bytes.Contains(field, []byte{'='}) {
+			return fmt.Errorf("Token does not contain key value pair: %s", field)
+		}
 		key, val := sasl.ExtractKeyValue(field, '=')
 
-		val = bytes.Trim(val, "\"")
+		val = unquote(val)
 		switch string(key) {
 		case "realm":
 			c.realms = bytes.Fields(val)
 		case "qop":
-			c.qop = bytes.Fields(val)
+			c.qop = splitList(val)
+		case "cipher":
+			c.ciphers = splitList(val)
+		case "maxbuf":
+			maxbuf, err := strconv.Atoi(string(val))
+			if err != nil || maxbuf <= 0 || maxbuf > MAX_MAXBUF {
+				return fmt.Errorf("Wrong maxbuf value: %s", val)
+			}
+			c.maxbuf = maxbuf
 		default:
 			if err := fmap.Set(string(key), val); err != nil {
 				return err
@@ -96,3 +132,10 @@ func (c *challenge) parseChallenge(challenge []byte) error {
 		return nil
 	})
 }
+
+// Splits list of values separated by commas and spaces
+func splitList(val []byte) [][]byte {
+	return bytes.FieldsFunc(val, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
@@ -0,0 +1,13 @@
+package digest
+
+import "fmt"
+
+type WrongClientMessage string
+
+func (wcm WrongClientMessage) Error() string {
+	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
+}
+
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
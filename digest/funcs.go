@@ -6,8 +6,36 @@ import (
 	"github.com/goxmpp/sasl"
 )
 
+// Used for all comparisons of secret-derived values. Variable allows tests to ensure it's used
+var secureEqual = sasl.SecureEqual
+
+// Makes key/value pair with quoted value escaping quotes and backslashes in it
 func makeKV(key string, val []byte) []byte {
-	return sasl.MakeKeyValue([]byte(key), append(append([]byte{'"'}, val...), '"'))
+	quoted := []byte{'"'}
+	for _, b := range val {
+		if b == '"' || b == '\\' {
+			quoted = append(quoted, '\\')
+		}
+		quoted = append(quoted, b)
+	}
+	return sasl.MakeKeyValue([]byte(key), append(quoted, '"'))
+}
+
+// Removes quotes and escaping of quoted value, tokens are returned as is
+func unquote(val []byte) []byte {
+	if len(val) < 2 || val[0] != '"' || val[len(val)-1] != '"' {
+		return val
+	}
+
+	val = val[1 : len(val)-1]
+	res := make([]byte, 0, len(val))
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\\' && i+1 < len(val) {
+			i++
+		}
+		res = append(res, val[i])
+	}
+	return res
 }
 
 func appendKVQuoted(kvs [][]byte, key string, val []byte) [][]byte {
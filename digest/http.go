@@ -0,0 +1,279 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+
+	"github.com/goxmpp/sasl"
+)
+
+// HTTP Digest access authentication described in RFC 7616
+
+const HTTP_SCHEME = "Digest"
+
+// Hash functions of HTTP Digest algorithms. Each of them has -sess variant
+var httpHashes = map[string]func() hash.Hash{
+	"MD5":         md5.New,
+	"SHA-256":     sha256.New,
+	"SHA-512-256": sha512.New512_256,
+}
+
+// HTTP Digest algorithms in order of client's preference
+var HTTPAlgorithms = []string{"SHA-512-256", "SHA-256", "MD5"}
+
+// Challenge sent in WWW-Authenticate header
+type HTTPChallenge struct {
+	Realm     string
+	Domain    string
+	Nonce     string
+	Opaque    string
+	Stale     bool
+	Algorithm string
+	QOPs      []string
+	Charset   string
+	UserHash  bool
+}
+
+// Credentials sent in Authorization header
+type HTTPCredentials struct {
+	Username  string // User name or its hash if UserHash is set
+	Realm     string
+	URI       string
+	Algorithm string
+	Nonce     string
+	CNonce    string
+	NC        string // Nonce count as 8 hex digits
+	QOP       string
+	Response  string
+	Opaque    string
+	UserHash  bool
+}
+
+// Parses value of WWW-Authenticate header with Digest challenge
+func ParseHTTPChallenge(header string) (*HTTPChallenge, error) {
+	params, err := parseHTTPHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &HTTPChallenge{
+		Realm:     params["realm"],
+		Domain:    params["domain"],
+		Nonce:     params["nonce"],
+		Opaque:    params["opaque"],
+		Stale:     strings.EqualFold(params["stale"], "true"),
+		Algorithm: params["algorithm"],
+		Charset:   params["charset"],
+		UserHash:  strings.EqualFold(params["userhash"], "true"),
+	}
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if qop = strings.TrimSpace(qop); qop != "" {
+			c.QOPs = append(c.QOPs, qop)
+		}
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = "MD5"
+	}
+
+	if c.Nonce == "" {
+		return nil, errors.New("Challenge does not contain nonce")
+	}
+	return c, nil
+}
+
+// Formats challenge as value of WWW-Authenticate header
+func (c *HTTPChallenge) String() string {
+	params := [][]byte{}
+	params = appendKVQuoted(params, "realm", []byte(c.Realm))
+	params = appendKVQuoted(params, "domain", []byte(c.Domain))
+	params = appendKVQuoted(params, "qop", []byte(strings.Join(c.QOPs, ", ")))
+	params = appendKVQuoted(params, "nonce", []byte(c.Nonce))
+	params = appendKVQuoted(params, "opaque", []byte(c.Opaque))
+	params = appendKV(params, "algorithm", []byte(c.Algorithm))
+	params = appendKV(params, "charset", []byte(c.Charset))
+	if c.Stale {
+		params = appendKV(params, "stale", []byte("true"))
+	}
+	if c.UserHash {
+		params = appendKV(params, "userhash", []byte("true"))
+	}
+	return HTTP_SCHEME + " " + string(bytes.Join(params, []byte(", ")))
+}
+
+// Parses value of Authorization header with Digest credentials
+func ParseHTTPCredentials(header string) (*HTTPCredentials, error) {
+	params, err := parseHTTPHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &HTTPCredentials{
+		Username:  params["username"],
+		Realm:     params["realm"],
+		URI:       params["uri"],
+		Algorithm: params["algorithm"],
+		Nonce:     params["nonce"],
+		CNonce:    params["cnonce"],
+		NC:        params["nc"],
+		QOP:       params["qop"],
+		Response:  params["response"],
+		Opaque:    params["opaque"],
+		UserHash:  strings.EqualFold(params["userhash"], "true"),
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = "MD5"
+	}
+
+	if ext, ok := params["username*"]; ok {
+		if c.Username != "" {
+			return nil, errors.New("Both username and username* are provided")
+		}
+		if c.Username, err = decodeExtValue(ext); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Username == "" || c.Nonce == "" || c.URI == "" || c.Response == "" {
+		return nil, errors.New("Credentials miss required parameters")
+	}
+	return c, nil
+}
+
+// Formats credentials as value of Authorization header.
+// User names which can't be sent as quoted string are encoded as username*
+func (c *HTTPCredentials) String() string {
+	params := [][]byte{}
+	if isASCII(c.Username) || c.UserHash {
+		params = appendKVQuoted(params, "username", []byte(c.Username))
+	} else {
+		params = appendKV(params, "username*", []byte("UTF-8''"+url.PathEscape(c.Username)))
+	}
+	params = appendKVQuoted(params, "realm", []byte(c.Realm))
+	params = appendKVQuoted(params, "uri", []byte(c.URI))
+	params = appendKV(params, "algorithm", []byte(c.Algorithm))
+	params = appendKVQuoted(params, "nonce", []byte(c.Nonce))
+	params = appendKV(params, "nc", []byte(c.NC))
+	params = appendKVQuoted(params, "cnonce", []byte(c.CNonce))
+	params = appendKV(params, "qop", []byte(c.QOP))
+	params = appendKVQuoted(params, "response", []byte(c.Response))
+	params = appendKVQuoted(params, "opaque", []byte(c.Opaque))
+	if c.UserHash {
+		params = appendKV(params, "userhash", []byte("true"))
+	}
+	return HTTP_SCHEME + " " + string(bytes.Join(params, []byte(", ")))
+}
+
+// Calculates response for credentials. Username is the real name of the user even if
+// credentials contain its hash. Empty method gives rspauth value of Authentication-Info
+func (c *HTTPCredentials) Digest(username, password, method string) (string, error) {
+	h, sess, err := httpHash(c.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	a1 := hexHash(h, username, c.Realm, password)
+	if sess {
+		a1 = hexHash(h, a1, c.Nonce, c.CNonce)
+	}
+	a2 := hexHash(h, method, c.URI)
+
+	return hexHash(h, a1, c.Nonce, c.NC, c.CNonce, c.QOP, a2), nil
+}
+
+// Calculates hash of user name sent when userhash is used
+func HTTPUserHash(algorithm, username, realm string) (string, error) {
+	h, _, err := httpHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	return hexHash(h, username, realm), nil
+}
+
+// Returns hash function of algorithm and whether it is -sess variant
+func httpHash(algorithm string) (func() hash.Hash, bool, error) {
+	name, sess := strings.CutSuffix(strings.ToUpper(algorithm), "-SESS")
+	if h, ok := httpHashes[name]; ok {
+		return h, sess, nil
+	}
+	return nil, false, fmt.Errorf("Unsupported algorithm: %s", algorithm)
+}
+
+// Returns algorithm name in the case RFC 7616 uses, e.g. SHA-256-sess for sha-256-SESS
+func canonicalAlgorithm(algorithm string) string {
+	name, sess := strings.CutSuffix(strings.ToUpper(algorithm), "-SESS")
+	if sess {
+		return name + "-sess"
+	}
+	return name
+}
+
+// Hashes values joined with colon and returns hex encoded result
+func hexHash(h func() hash.Hash, values ...string) string {
+	tokens := make([][]byte, len(values))
+	for i, value := range values {
+		tokens[i] = []byte(value)
+	}
+
+	hasher := h()
+	hasher.Write(makeMessage(tokens...))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Parses header value checking it uses Digest scheme
+func parseHTTPHeader(header string) (map[string]string, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, HTTP_SCHEME) {
+		return nil, fmt.Errorf("Unexpected authentication scheme: %s", scheme)
+	}
+	return parseHTTPParams(params)
+}
+
+// Parses comma separated key/value pairs with token or quoted values
+func parseHTTPParams(params string) (map[string]string, error) {
+	values := make(map[string]string)
+	err := sasl.EachField([]byte(params), func(field []byte) error {
+		field = bytes.TrimSpace(field)
+		if bytes.IndexByte(field, '=') < 0 {
+			return fmt.Errorf("Token does not contain key value pair: %s", field)
+		}
+		key, val := sasl.ExtractKeyValue(field, '=')
+
+		name := strings.ToLower(string(bytes.TrimSpace(key)))
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("More than one occurance of %s found", name)
+		}
+		values[name] = string(unquote(bytes.TrimSpace(val)))
+		return nil
+	})
+	return values, err
+}
+
+// Decodes RFC 5987 value of username* parameter
+func decodeExtValue(ext string) (string, error) {
+	charset, rest, ok := strings.Cut(ext, "'")
+	if !ok || !strings.EqualFold(charset, "UTF-8") {
+		return "", fmt.Errorf("Unsupported encoding of username*: %s", ext)
+	}
+	_, value, ok := strings.Cut(rest, "'")
+	if !ok {
+		return "", fmt.Errorf("Wrong username* value: %s", ext)
+	}
+	return url.PathUnescape(value)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package digest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/goxmpp/sasl"
+)
+
+// http.RoundTripper authenticating requests with HTTP Digest when server asks for it.
+// Requests with body should have GetBody set, so they can be resent
+type HTTPTransport struct {
+	Base      http.RoundTripper // Transport used to send requests. http.DefaultTransport is used if nil
+	Username  string
+	Password  string
+	Generator sasl.NonceGenerator // optional, nil means default generator
+}
+
+func (t *HTTPTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *HTTPTransport) generator() sasl.NonceGenerator {
+	if t.Generator != nil {
+		return t.Generator
+	}
+	return DefaultGenerator
+}
+
+// Sends request and answers the strongest Digest challenge if server responds with one.
+// Error is returned if server's Authentication-Info is missing or can't be verified
+func (t *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := selectChallenge(resp)
+	if challenge == nil {
+		return resp, nil
+	}
+	discardBody(resp)
+
+	creds, err := t.credentials(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp, err = t.send(req, creds.String()); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	if err := t.verify(resp, creds); err != nil {
+		discardBody(resp)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Answers challenge for request
+func (t *HTTPTransport) credentials(req *http.Request, challenge *HTTPChallenge) (*HTTPCredentials, error) {
+	cnonce, err := t.generator().GetNonce(cnonce_size)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &HTTPCredentials{
+		Username:  t.Username,
+		Realm:     challenge.Realm,
+		URI:       req.URL.RequestURI(),
+		Algorithm: challenge.Algorithm,
+		Nonce:     challenge.Nonce,
+		CNonce:    string(cnonce),
+		NC:        "00000001",
+		QOP:       "auth",
+		Opaque:    challenge.Opaque,
+		UserHash:  challenge.UserHash,
+	}
+	if creds.UserHash {
+		if creds.Username, err = HTTPUserHash(creds.Algorithm, t.Username, creds.Realm); err != nil {
+			return nil, err
+		}
+	}
+	if creds.Response, err = creds.Digest(t.Username, t.Password, req.Method); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// Checks rspauth of Authentication-Info header. Header is required,
+// so response of server which doesn't know user's password isn't accepted
+func (t *HTTPTransport) verify(resp *http.Response, creds *HTTPCredentials) error {
+	header := resp.Header.Get("Authentication-Info")
+	if header == "" {
+		return errors.New("Server did not send Authentication-Info")
+	}
+
+	info, err := parseHTTPParams(header)
+	if err != nil {
+		return err
+	}
+	if info["cnonce"] != creds.CNonce || info["nc"] != creds.NC {
+		return errors.New("Authentication-Info does not match request")
+	}
+
+	rspauth, err := creds.Digest(t.Username, t.Password, "")
+	if err != nil {
+		return err
+	}
+	if !secureEqual([]byte(rspauth), []byte(info["rspauth"])) {
+		return errors.New("Wrong rspauth received from server")
+	}
+	return nil
+}
+
+// Sends copy of request with Authorization header
+func (t *HTTPTransport) send(req *http.Request, authorization string) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("Request body can't be resent for authentication")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	r.Header.Set("Authorization", authorization)
+	return t.base().RoundTrip(r)
+}
+
+// Returns Digest challenge using the strongest supported algorithm with auth QOP
+func selectChallenge(resp *http.Response) *HTTPChallenge {
+	challenges := make(map[string]*HTTPChallenge)
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		c, err := ParseHTTPChallenge(header)
+		if err != nil || !sasl.Contains([]byte("auth"), stringsToBytes(c.QOPs)) {
+			continue
+		}
+		if _, _, err := httpHash(c.Algorithm); err == nil {
+			challenges[canonicalAlgorithm(c.Algorithm)] = c
+		}
+	}
+
+	for _, algo := range HTTPAlgorithms {
+		for _, name := range []string{algo, algo + "-sess"} {
+			if c, ok := challenges[name]; ok {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func stringsToBytes(values []string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, value := range values {
+		result[i] = []byte(value)
+	}
+	return result
+}
+
+func discardBody(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,306 @@
+package digest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Time nonce issued by HTTPHandler can be used for
+const HTTP_NONCE_LIFETIME = 5 * time.Minute
+
+// Maximal number of used nonces HTTPHandler tracks to reject replayed nonce counts.
+// When the limit is reached the oldest nonces are forgotten and become stale
+const HTTP_MAX_NONCES = 10000
+
+// Store able to find user by hash of its name. HTTPHandler uses it when userhash is enabled
+type UserHashStore interface {
+	UserByHash(algorithm, userhash, realm string) (string, error)
+}
+
+type httpContextKey struct{}
+
+// Returns name of the user authenticated by HTTPHandler
+func HTTPUserName(r *http.Request) string {
+	name, _ := r.Context().Value(httpContextKey{}).(string)
+	return name
+}
+
+// Nonce used by authenticated client
+type httpNonce struct {
+	nonce   string
+	expires time.Time
+}
+
+// Middleware authenticating requests with HTTP Digest before passing them to next handler
+type HTTPHandler struct {
+	next       http.Handler
+	realm      string
+	store      sasl.PasswordStore
+	gen        sasl.NonceGenerator
+	algorithms []string
+	userhash   bool
+	opaque     string
+	key        []byte // Key nonces are signed with, so they are not stored until used
+
+	mu     sync.Mutex
+	counts map[string]uint64 // Highest nonce count of used nonces
+	used   []httpNonce       // Used nonces in order of their first use
+	floor  time.Time         // Nonces expiring before it are stale since they could be forgotten
+}
+
+// Creates middleware offering SHA-256 and MD5 algorithms and authenticating users with passwords from store
+func NewHTTPHandler(next http.Handler, realm string, store sasl.PasswordStore) *HTTPHandler {
+	return &HTTPHandler{
+		next:       next,
+		realm:      realm,
+		store:      store,
+		gen:        DefaultGenerator,
+		algorithms: []string{"SHA-256", "MD5"},
+		counts:     make(map[string]uint64),
+	}
+}
+
+// Sets algorithms offered to clients, one challenge is sent for each of them in provided order
+func (h *HTTPHandler) SetAlgorithms(algorithms ...string) {
+	h.algorithms = algorithms
+}
+
+// Sets generator used for nonces, opaque value and key nonces are signed with
+func (h *HTTPHandler) SetGenerator(gen sasl.NonceGenerator) {
+	h.gen = gen
+}
+
+// Enables hashed user names. Password store should implement UserHashStore
+func (h *HTTPHandler) SetUserHash(userhash bool) {
+	h.userhash = userhash
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	creds, err := ParseHTTPCredentials(r.Header.Get("Authorization"))
+	if err != nil {
+		h.challenge(w, false)
+		return
+	}
+
+	username, password, ok := h.authenticate(r, creds)
+	if !ok {
+		h.challenge(w, false)
+		return
+	}
+	if !h.useNonce(creds) {
+		// Credentials are right, but nonce can't be used anymore
+		h.challenge(w, true)
+		return
+	}
+
+	rspauth, err := creds.Digest(username, password, "")
+	if err != nil {
+		h.challenge(w, false)
+		return
+	}
+
+	w.Header().Set("Authentication-Info", string(sasl.MakeMessage(
+		sasl.MakeKeyValue([]byte("qop"), []byte(creds.QOP)),
+		makeKV("rspauth", []byte(rspauth)),
+		makeKV("cnonce", []byte(creds.CNonce)),
+		sasl.MakeKeyValue([]byte("nc"), []byte(creds.NC)),
+	)))
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), httpContextKey{}, username)))
+}
+
+// Verifies credentials and returns name and password of authenticated user
+func (h *HTTPHandler) authenticate(r *http.Request, creds *HTTPCredentials) (string, string, bool) {
+	if !h.offered(creds.Algorithm) || creds.Realm != h.realm || creds.QOP != "auth" ||
+		creds.URI != r.URL.RequestURI() || !secureEqual([]byte(creds.Opaque), []byte(h.getOpaque())) {
+		return "", "", false
+	}
+
+	username := creds.Username
+	if creds.UserHash {
+		users, ok := h.store.(UserHashStore)
+		if !h.userhash || !ok {
+			return "", "", false
+		}
+		name, err := users.UserByHash(creds.Algorithm, creds.Username, h.realm)
+		if err != nil {
+			return "", "", false
+		}
+		username = name
+	}
+
+	password, err := h.store.Password(username)
+	if err != nil {
+		return "", "", false
+	}
+	expected, err := creds.Digest(username, password, r.Method)
+	if err != nil {
+		return "", "", false
+	}
+	return username, password, secureEqual([]byte(expected), []byte(creds.Response))
+}
+
+func (h *HTTPHandler) offered(algorithm string) bool {
+	for _, algo := range h.algorithms {
+		if strings.EqualFold(algo, algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks nonce was issued by handler, is not expired and nonce count was not used before
+func (h *HTTPHandler) useNonce(creds *HTTPCredentials) bool {
+	nc, err := strconv.ParseUint(creds.NC, 16, 64)
+	if err != nil || len(creds.NC) != 8 {
+		return false
+	}
+
+	expires, ok := h.checkNonce(creds.Nonce)
+	now := time.Now()
+	if !ok || now.After(expires) {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expire(now)
+	if !expires.After(h.floor) {
+		return false
+	}
+
+	count, ok := h.counts[creds.Nonce]
+	if nc <= count {
+		return false
+	}
+	if !ok {
+		if len(h.used) >= HTTP_MAX_NONCES {
+			h.forget()
+		}
+		h.used = append(h.used, httpNonce{nonce: creds.Nonce, expires: expires})
+	}
+	h.counts[creds.Nonce] = nc
+	return true
+}
+
+// Sends one challenge for each of offered algorithms
+func (h *HTTPHandler) challenge(w http.ResponseWriter, stale bool) {
+	nonce, err := h.newNonce()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for _, algo := range h.algorithms {
+		c := &HTTPChallenge{
+			Realm:     h.realm,
+			QOPs:      []string{"auth"},
+			Nonce:     nonce,
+			Opaque:    h.getOpaque(),
+			Stale:     stale,
+			Algorithm: algo,
+			Charset:   "UTF-8",
+			UserHash:  h.userhash,
+		}
+		w.Header().Add("WWW-Authenticate", c.String())
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// Returns opaque value generating it on the first use
+func (h *HTTPHandler) getOpaque() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.opaque == "" {
+		if opaque, err := h.gen.GetNonce(nonce_size); err == nil {
+			h.opaque = string(opaque)
+		}
+	}
+	return h.opaque
+}
+
+// Returns key nonces are signed with generating it on the first use
+func (h *HTTPHandler) getKey() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.key == nil {
+		key, err := h.gen.GetNonce(sha256.Size)
+		if err != nil {
+			return nil, err
+		}
+		h.key = key
+	}
+	return h.key, nil
+}
+
+// Generates nonce containing its expiration time, random data and their HMAC,
+// so nonces don't need to be stored until client uses them
+func (h *HTTPHandler) newNonce() (string, error) {
+	key, err := h.getKey()
+	if err != nil {
+		return "", err
+	}
+	random, err := h.gen.GetNonce(nonce_size)
+	if err != nil {
+		return "", err
+	}
+
+	data := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(HTTP_NONCE_LIFETIME).Unix()))
+	data = append(data, random...)
+	return base64.StdEncoding.EncodeToString(append(data, nonceMAC(key, data)...)), nil
+}
+
+// Verifies nonce signature and returns its expiration time
+func (h *HTTPHandler) checkNonce(nonce string) (time.Time, bool) {
+	key, err := h.getKey()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || len(data) < 8+sha256.Size {
+		return time.Time{}, false
+	}
+	data, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !secureEqual(nonceMAC(key, data), mac) {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(data)), 0), true
+}
+
+func nonceMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Forgets expired nonces from the beginning of used ones. Should be called with mutex locked
+func (h *HTTPHandler) expire(now time.Time) {
+	for len(h.used) > 0 && now.After(h.used[0].expires) {
+		delete(h.counts, h.used[0].nonce)
+		h.used = h.used[1:]
+	}
+}
+
+// Forgets the oldest used nonce making all nonces expiring before it stale.
+// Should be called with mutex locked
+func (h *HTTPHandler) forget() {
+	oldest := h.used[0]
+	delete(h.counts, oldest.nonce)
+	h.used = h.used[1:]
+	if oldest.expires.After(h.floor) {
+		h.floor = oldest.expires
+	}
+}
@@ -0,0 +1,303 @@
+package digest_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl/digest"
+)
+
+type httpPasswords map[string]string
+
+func (p httpPasswords) Password(username string) (string, error) {
+	if password, ok := p[username]; ok {
+		return password, nil
+	}
+	return "", errors.New("Unknown user")
+}
+
+func (p httpPasswords) UserByHash(algorithm, userhash, realm string) (string, error) {
+	for username := range p {
+		if hash, _ := digest.HTTPUserHash(algorithm, username, realm); hash == userhash {
+			return username, nil
+		}
+	}
+	return "", errors.New("Unknown user")
+}
+
+// Examples of RFC 7616 section 3.9.1
+func TestHTTPDigestRFCExample(t *testing.T) {
+	creds := &digest.HTTPCredentials{
+		Username: "Mufasa",
+		Realm:    "http-auth@example.org",
+		URI:      "/dir/index.html",
+		Nonce:    "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+		NC:       "00000001",
+		CNonce:   "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+		QOP:      "auth",
+		Opaque:   "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+	}
+
+	for algo, expected := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		creds.Algorithm = algo
+		response, err := creds.Digest("Mufasa", "Circle of Life", "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response != expected {
+			t.Errorf("Wrong %s response: %s", algo, response)
+		}
+	}
+}
+
+func TestHTTPCredentialsRoundTrip(t *testing.T) {
+	creds := &digest.HTTPCredentials{
+		Username:  "Jäsøn Doe",
+		Realm:     "api@example.org",
+		URI:       "/doe.json?a=b,c",
+		Algorithm: "SHA-256-sess",
+		Nonce:     "nonce",
+		NC:        "0000000a",
+		CNonce:    "cnonce",
+		QOP:       "auth",
+		Response:  "abcdef",
+		Opaque:    "opaque",
+	}
+
+	header := creds.String()
+	if !strings.Contains(header, "username*=UTF-8''J%C3%A4s%C3%B8n%20Doe") {
+		t.Fatal("Non-ASCII user name should be encoded as username*:", header)
+	}
+
+	parsed, err := digest.ParseHTTPCredentials(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *creds {
+		t.Errorf("Credentials changed after parsing: %+v", parsed)
+	}
+
+	if _, err := digest.ParseHTTPCredentials("Basic dXNlcjpwYXNz"); err == nil {
+		t.Error("Credentials of other scheme should be rejected")
+	}
+}
+
+func TestHTTPChallengeRoundTrip(t *testing.T) {
+	c := &digest.HTTPChallenge{
+		Realm:     "http-auth@example.org",
+		QOPs:      []string{"auth", "auth-int"},
+		Nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+		Opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+		Stale:     true,
+		Algorithm: "SHA-512-256",
+		Charset:   "UTF-8",
+		UserHash:  true,
+	}
+
+	parsed, err := digest.ParseHTTPChallenge(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != c.String() {
+		t.Errorf("Challenge changed after parsing: %s", parsed)
+	}
+}
+
+func newHTTPServer(t *testing.T, configure func(*digest.HTTPHandler)) *httptest.Server {
+	h := digest.NewHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+digest.HTTPUserName(r))
+	}), "example.org", httpPasswords{"user": "pencil"})
+	if configure != nil {
+		configure(h)
+	}
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHTTPTransport(t *testing.T) {
+	for name, configure := range map[string]func(*digest.HTTPHandler){
+		"default":  nil,
+		"sha-512":  func(h *digest.HTTPHandler) { h.SetAlgorithms("MD5", "SHA-512-256") },
+		"sess":     func(h *digest.HTTPHandler) { h.SetAlgorithms("SHA-256-sess") },
+		"userhash": func(h *digest.HTTPHandler) { h.SetUserHash(true) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newHTTPServer(t, configure)
+
+			client := &http.Client{Transport: &digest.HTTPTransport{Username: "user", Password: "pencil"}}
+			resp, body := get(t, client, srv.URL+"/path?query=1")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatal("Unexpected status:", resp.Status)
+			}
+			if body != "hello user" {
+				t.Error("Unexpected body:", body)
+			}
+			if resp.Header.Get("Authentication-Info") == "" {
+				t.Error("Authentication-Info is missing")
+			}
+		})
+	}
+}
+
+func TestHTTPTransportWrongPassword(t *testing.T) {
+	srv := newHTTPServer(t, nil)
+
+	client := &http.Client{Transport: &digest.HTTPTransport{Username: "user", Password: "wrong"}}
+	if resp, _ := get(t, client, srv.URL); resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Wrong password should be rejected, status:", resp.Status)
+	}
+}
+
+func TestHTTPHandlerReplay(t *testing.T) {
+	srv := newHTTPServer(t, nil)
+
+	var authorization string
+	client := &http.Client{Transport: &digest.HTTPTransport{
+		Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if header := r.Header.Get("Authorization"); header != "" {
+				authorization = header
+			}
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+		Username: "user",
+		Password: "pencil",
+	}}
+	if resp, _ := get(t, client, srv.URL); resp.StatusCode != http.StatusOK {
+		t.Fatal("Unexpected status:", resp.Status)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Authorization", authorization)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Replayed credentials should be rejected, status:", resp.Status)
+	}
+	if !strings.Contains(resp.Header.Get("WWW-Authenticate"), "stale=true") {
+		t.Error("Challenge should be stale:", resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestHTTPTransportWrongRspauth(t *testing.T) {
+	for name, info := range map[string]string{
+		"wrong":   `qop=auth, rspauth="0000", cnonce="%s", nc=%s`,
+		"missing": "",
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			creds, err := digest.ParseHTTPCredentials(r.Header.Get("Authorization"))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", (&digest.HTTPChallenge{
+					Realm: "example.org", Nonce: "nonce", QOPs: []string{"auth"}, Algorithm: "SHA-256",
+				}).String())
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if info != "" {
+				w.Header().Set("Authentication-Info", fmt.Sprintf(info, creds.CNonce, creds.NC))
+			}
+		}))
+
+		client := &http.Client{Transport: &digest.HTTPTransport{Username: "user", Password: "pencil"}}
+		if _, err := client.Get(srv.URL); err == nil {
+			t.Error(name, "rspauth should be reported")
+		}
+		srv.Close()
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestHTTPQuotedEscaping(t *testing.T) {
+	c := &digest.HTTPChallenge{
+		Realm:     `say "hi", \o/`,
+		QOPs:      []string{"auth"},
+		Nonce:     "nonce",
+		Algorithm: "SHA-256",
+	}
+
+	parsed, err := digest.ParseHTTPChallenge(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Realm != c.Realm {
+		t.Errorf("Realm changed after parsing: %s", parsed.Realm)
+	}
+}
+
+func TestHTTPLowercaseAlgorithm(t *testing.T) {
+	srv := newHTTPServer(t, func(h *digest.HTTPHandler) { h.SetAlgorithms("sha-256") })
+
+	client := &http.Client{Transport: &digest.HTTPTransport{Username: "user", Password: "pencil"}}
+	if resp, body := get(t, client, srv.URL); resp.StatusCode != http.StatusOK || body != "hello user" {
+		t.Error("Algorithm name should be case-insensitive, status:", resp.Status)
+	}
+}
+
+func TestHTTPHandlerForgedNonce(t *testing.T) {
+	srv := newHTTPServer(t, nil)
+
+	resp, _ := get(t, http.DefaultClient, srv.URL)
+	challenge, err := digest.ParseHTTPChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nonce := range []string{"forged", challenge.Nonce[:len(challenge.Nonce)-4] + "AAA="} {
+		creds := &digest.HTTPCredentials{
+			Username:  "user",
+			Realm:     challenge.Realm,
+			URI:       "/",
+			Algorithm: challenge.Algorithm,
+			Nonce:     nonce,
+			CNonce:    "cnonce",
+			NC:        "00000001",
+			QOP:       "auth",
+			Opaque:    challenge.Opaque,
+		}
+		if creds.Response, err = creds.Digest("user", "pencil", "GET"); err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Authorization", creds.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Nonce %s was not issued by handler and should be rejected", nonce)
+		}
+	}
+}
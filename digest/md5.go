@@ -1,15 +1,30 @@
 package digest
 
-import "github.com/goxmpp/sasl"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goxmpp/sasl"
+)
 
 const (
 	nonce_size  = 16
 	cnonce_size = 10
 )
 
+// The only algorithm RFC 2831 defines
+const ALGORITHM_MD5_SESS = "md5-sess"
+
 type digest struct {
 	*challenge
 	*response
+
+	user, password string             // Credentials used by client's Step
+	store          sasl.PasswordStore // Passwords lookup used by server's Step
+	step           int                // Number of Step calls made
+	ciphers        []string           // Ciphers client accepts in order of preference
 }
 
 type Server digest
@@ -26,43 +41,114 @@ type Options struct {
 	DigestURI  string
 	AuthID     string
 	ServerType string
+	MaxBuf     int      // Maximal size of security layer buffer accepted from peer, 0 means default
+	Ciphers    []string // Ciphers offered by server or accepted by client for auth-conf. Ciphers is used if empty
 }
 
 var DefaultGenerator sasl.Generator
 
-func newDigest(opts *Options) *digest {
+func newDigest(opts *Options) (*digest, error) {
 	if opts.Generator == nil {
 		opts.Generator = DefaultGenerator
 	}
 
-	return &digest{challenge: newChallenge(opts), response: newResponse(opts)}
+	c, err := newChallenge(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newResponse(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest{challenge: c, response: r, ciphers: opts.Ciphers}, nil
 }
 
-func NewServer(opts *Options) *Server {
-	return (*Server)(newDigest(opts))
+func NewServer(opts *Options) (*Server, error) {
+	m, err := newDigest(opts)
+	return (*Server)(m), err
 }
 
-func NewClient(opts *Options) *Client {
-	return (*Client)(newDigest(opts))
+func NewClient(opts *Options) (*Client, error) {
+	m, err := newDigest(opts)
+	return (*Client)(m), err
 }
 
 // Algorithm, Nonce, Realm, Charset and QOP will be set from challenge message
 func NewClientFromChallenge(chal []byte, opts *Options) (*Client, error) {
-	m := &digest{challenge: &challenge{}, response: newResponse(opts)}
+	if opts.Generator == nil {
+		opts.Generator = DefaultGenerator
+	}
 
-	if err := m.challenge.parseChallenge(chal); err != nil {
+	r, err := newResponse(opts)
+	if err != nil {
 		return nil, err
 	}
-	m.response.nonce = m.challenge.nonce
-	m.response.charset = m.challenge.charset
-	if len(m.challenge.realms) > 0 {
-		m.response.realm = m.challenge.realms[0]
+
+	m := (*Client)(&digest{challenge: &challenge{}, response: r, ciphers: opts.Ciphers})
+
+	if err := m.ParseChallenge(chal); err != nil {
+		return nil, err
 	}
-	if len(m.challenge.qop) > 0 {
-		m.response.qop = m.challenge.qop[0]
+
+	return m, nil
+}
+
+// Parses challenge received from server.
+// Algorithm, Nonce, Realm, Charset and QOP will be set from challenge message.
+// QOP provided with options should be offered by server, auth is preferred otherwise
+func (m *Client) ParseChallenge(chal []byte) error {
+	c := &challenge{}
+	if err := c.parseChallenge(chal); err != nil {
+		return err
+	}
+
+	if len(c.algo) > 0 && !bytes.EqualFold(c.algo, []byte(ALGORITHM_MD5_SESS)) {
+		return fmt.Errorf("Unsupported algorithm: %s", c.algo)
 	}
 
-	return (*Client)(m), nil
+	m.challenge = c
+	m.response.nonce = c.nonce
+	m.response.charset = c.charset
+	if len(c.realms) > 0 {
+		m.response.realm = c.realms[0]
+	}
+	switch {
+	case len(m.response.qop) > 0:
+		// Requested security layer must not be silently downgraded
+		if len(c.qop) > 0 && !sasl.Contains(m.response.qop, c.qop) {
+			return fmt.Errorf("Server does not offer requested QOP: %s", m.response.qop)
+		}
+		if len(c.qop) == 0 && !bytes.Equal(m.response.qop, []byte(QOP_AUTH)) {
+			return fmt.Errorf("Server does not offer requested QOP: %s", m.response.qop)
+		}
+	case len(c.qop) == 0 || sasl.Contains([]byte(QOP_AUTH), c.qop):
+		m.response.qop = []byte(QOP_AUTH)
+	default:
+		m.response.qop = c.qop[0]
+	}
+	if bytes.Equal(m.response.qop, []byte(QOP_AUTH_CONF)) {
+		m.response.cipher = selectCipher(m.ciphers, c.ciphers)
+		if m.response.cipher == nil {
+			return errors.New("Server does not offer acceptable cipher")
+		}
+	}
+
+	return nil
+}
+
+// Returns the first of accepted ciphers server offers
+func selectCipher(accepted []string, offered [][]byte) []byte {
+	if len(accepted) == 0 {
+		accepted = Ciphers
+	}
+	for _, cipher := range accepted {
+		if sasl.Contains([]byte(cipher), offered) {
+			return []byte(cipher)
+		}
+	}
+	return nil
 }
 
 func (m *Server) Challenge() []byte {
@@ -81,6 +167,7 @@ func (m *Server) UserName() string {
 }
 
 func (m *Client) Response(username, password string) []byte {
+	m.response.username = []byte(username) // Password hash depends on user name
 	m.response.HashPassword([]byte(password))
 	return m.response.response([]byte(username), m.challenge)
 }
@@ -90,6 +177,32 @@ func (m *Client) ResponseHashed(username string, password []byte) []byte {
 	return m.response.response([]byte(username), m.challenge)
 }
 
+// Verifies rspauth of server's final message proving server knows user's password
+func (m *Client) CheckFinal(rspauth []byte) error {
+	var value []byte
+	err := sasl.EachField(rspauth, func(field []byte) error {
+		if !bytes.Contains(field, []byte{'='}) {
+			return fmt.Errorf("Token does not contain key value pair: %s", field)
+		}
+		key, val := sasl.ExtractKeyValue(field, '=')
+		if string(key) == "rspauth" {
+			value = unquote(val)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(value) == 0 {
+		return errors.New("Server did not send rspauth")
+	}
+	if !secureEqual(m.response.responseAuth(), value) {
+		return errors.New("Wrong rspauth received from server")
+	}
+	return nil
+}
+
 func (m *Server) Final() []byte {
 	if m.response.ok {
 		return sasl.MakeKeyValue([]byte("rspauth"), m.response.responseAuth())
@@ -108,5 +221,91 @@ func (m *Server) ValidateHashed(password []byte) error {
 }
 
 func (m *Server) ParseResponse(response []byte) error {
-	return m.response.parseResponse(response, m.challenge)
+	if err := m.response.parseResponse(response, m.challenge); err != nil {
+		return WrongClientMessage(err.Error())
+	}
+	return nil
+}
+
+// Sets user name and password used to authenticate with Step
+func (m *Client) SetCredentials(username, password string) {
+	m.user = username
+	m.password = password
+}
+
+// Implements sasl.ClientMechanism. SetCredentials should be called before this method usage
+func (m *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch m.step {
+	case 0:
+		if len(challenge) == 0 {
+			// DIGEST-MD5 has no initial response
+			return nil, false, nil
+		}
+		if err := m.ParseChallenge(challenge); err != nil {
+			return nil, false, err
+		}
+		m.step++
+		return m.Response(m.user, m.password), false, nil
+	case 1:
+		if err := m.CheckFinal(challenge); err != nil {
+			return nil, false, err
+		}
+		m.step++
+		return nil, true, nil
+	}
+
+	return nil, false, errors.New("Authentication exchange is already completed")
+}
+
+// Sets passwords store used to authenticate users with Step
+func (m *Server) SetPasswordStore(store sasl.PasswordStore) {
+	m.store = store
+}
+
+// Implements sasl.ServerMechanism. SetPasswordStore should be called before this method usage
+func (m *Server) Step(response []byte) ([]byte, bool, error) {
+	switch m.step {
+	case 0:
+		if len(response) != 0 {
+			return nil, false, WrongClientMessage("Unexpected initial response")
+		}
+		m.step++
+		return m.Challenge(), false, nil
+	case 1:
+		if err := m.ParseResponse(response); err != nil {
+			return nil, false, err
+		}
+		if m.store == nil {
+			return nil, false, errors.New("Password store is not set")
+		}
+		password, err := m.store.Password(m.UserName())
+		if err != nil {
+			return nil, false, err
+		}
+		if err := m.Validate(password); err != nil {
+			return nil, false, err
+		}
+		m.step++
+		return m.Final(), true, nil
+	}
+
+	return nil, false, errors.New("Authentication exchange is already completed")
+}
+
+// Wraps connection into security layer negotiated with server.
+// Connection is returned as is if auth QOP was used
+func (m *Client) Wrap(conn net.Conn) (net.Conn, error) {
+	if len(m.response.hpassword) == 0 {
+		return nil, errors.New("Response should be generated first")
+	}
+	return newSecurityLayer(conn, m.response, m.challenge.maxbuf, m.response.maxbuf, true)
+}
+
+// Wraps connection into security layer negotiated with client.
+// Connection is returned as is if auth QOP was used
+func (m *Server) Wrap(conn net.Conn) (net.Conn, error) {
+	if !m.response.ok {
+		return nil, errors.New("Client is not authenticated")
+	}
+	return newSecurityLayer(conn, m.response, m.response.maxbuf, m.challenge.maxbuf, false)
 }
@@ -29,19 +29,18 @@ const (
 	std_password = "secret"
 
 	std_challenge = `realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`
-	std_respnse   = `charset=utf-8,username="chris",realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",nc=00000001,cnonce="OA6MHXh6VqTrRk",digest-uri="imap/elwood.innosoft.com",response=3d249750661c0fd2296cdf4bb0ea7af1,qop=auth`
+	std_respnse   = `charset=utf-8,username="chris",realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",nc=00000001,cnonce="OA6MHXh6VqTrRk",digest-uri="imap/elwood.innosoft.com",response=d388dad90d4bbd760a152321f2143af7,qop=auth`
 	std_respauth  = `rspauth=ea40f60335c427b5527b84dbabcdfffd`
-	//std_respnse  = `charset=utf-8,username="chris",realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",nc=00000001,cnonce="OA6MHXh6VqTrRk",digest-uri="imap/elwood.innosoft.com",response=d388dad90d4bbd760a152321f2143af7,qop=auth`
 )
 
 type StdGenerator struct{}
 
-func (g StdGenerator) GetNonce(ln int) []byte {
+func (g StdGenerator) GetNonce(ln int) ([]byte, error) {
 	if ln == 16 {
-		return []byte(std_challenge_nonce) // Client's nonce
+		return []byte(std_challenge_nonce), nil // Client's nonce
 	}
 
-	return []byte(std_reply_cnonce) // Server's nonce
+	return []byte(std_reply_cnonce), nil // Server's nonce
 }
 
 func sortFields(str string) string {
@@ -58,7 +57,10 @@ func TestStdExample(t *testing.T) {
 		QOPs:      []string{"auth"},
 		DigestURI: std_reply_digesturi,
 	}
-	s := digest.NewServer(opts)
+	s, err := digest.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	got := sortFields(string(s.Challenge()))
 	expect := sortFields(std_challenge)
@@ -96,3 +98,117 @@ func TestStdExample(t *testing.T) {
 		t.Fatal("Wrong Auth response")
 	}
 }
+
+// RFC 2831 example exchange driven by client's Step
+func TestStdExampleClient(t *testing.T) {
+	c, err := digest.NewClient(&digest.Options{Generator: &StdGenerator{}, DigestURI: std_reply_digesturi})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCredentials(std_reply_username, std_password)
+
+	if resp, done, err := c.Step(nil); err != nil || done || resp != nil {
+		t.Fatal("DIGEST-MD5 has no initial response", err)
+	}
+
+	resp, done, err := c.Step([]byte(std_challenge))
+	if err != nil || done {
+		t.Fatal("Could not answer challenge", err)
+	}
+	if sortFields(string(resp)) != sortFields(std_respnse) {
+		t.Logf("    Response  %s", resp)
+		t.Logf("STD Response  %s", std_respnse)
+		t.Fatal("Wrong response")
+	}
+
+	if _, done, err := c.Step([]byte(std_respauth)); err != nil || !done {
+		t.Fatal("Server's rspauth should be accepted", err)
+	}
+}
+
+func TestCheckFinal(t *testing.T) {
+	c, err := digest.NewClientFromChallenge([]byte(std_challenge), &digest.Options{
+		Generator: &StdGenerator{},
+		DigestURI: std_reply_digesturi,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Response(std_reply_username, std_password)
+
+	if err := c.CheckFinal([]byte(std_respauth)); err != nil {
+		t.Error("RFC 2831 rspauth should be accepted:", err)
+	}
+	for _, final := range []string{"", "rspauth=ea40f60335c427b5527b84dbabcdfffe", "rspauth=", "nonce=\"OA6MG9tEQGm2hh\""} {
+		if err := c.CheckFinal([]byte(final)); err == nil {
+			t.Errorf("Final message '%s' should be rejected", final)
+		}
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	for _, algo := range []string{"md5", "sha-256"} {
+		// md5 would make H(A1) and security layer keys the same in every session
+		if _, err := digest.NewClientFromChallenge([]byte(`nonce="abc",algorithm=`+algo), &digest.Options{}); err == nil {
+			t.Errorf("Algorithm %s should be rejected", algo)
+		}
+		if _, err := digest.NewServer(&digest.Options{Algorithm: algo}); err == nil {
+			t.Errorf("Server should not offer %s", algo)
+		}
+	}
+}
+
+func TestCharsetOption(t *testing.T) {
+	opts := &digest.Options{Realms: []string{"example.com"}, Charset: "utf-8", DigestURI: "xmpp/example.com"}
+	s, err := digest.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := string(s.Challenge())
+	if !strings.Contains(challenge, "algorithm=md5-sess") || !strings.Contains(challenge, "charset=utf-8") {
+		t.Fatal("Wrong challenge generated:", challenge)
+	}
+
+	c, err := digest.NewClientFromChallenge(s.Challenge(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseResponse(c.Response("user", "pencil")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("pencil"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMalformedChallenge(t *testing.T) {
+	c, err := digest.NewClient(&digest.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, challenge := range []string{"garbage", `nonce="abc",garbage`} {
+		if _, _, err := c.Step([]byte(challenge)); err == nil {
+			t.Errorf("Challenge '%s' should be rejected", challenge)
+		}
+	}
+}
+
+func TestServerWithoutStore(t *testing.T) {
+	opts := &digest.Options{Realms: []string{"example.com"}, DigestURI: "xmpp/example.com"}
+	s, err := digest.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, _, err := s.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := digest.NewClientFromChallenge(challenge, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Step(c.Response("user", "pencil")); err == nil {
+		t.Error("Server without password store should fail")
+	}
+}
@@ -0,0 +1,41 @@
+package digest
+
+import "github.com/goxmpp/sasl"
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:     "DIGEST-MD5",
+		HashBits: 128,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			c, err := NewClient(&Options{
+				Generator: cfg.Generator,
+				DigestURI: cfg.Service + "/" + cfg.Host,
+				AuthID:    cfg.AuthID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			c.SetCredentials(cfg.Username, cfg.Password)
+			return c, nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			opts := &Options{Generator: cfg.Generator, Algorithm: "md5-sess"}
+			if realm := realmOf(cfg); realm != "" {
+				opts.Realms = []string{realm}
+			}
+			s, err := NewServer(opts)
+			if err != nil {
+				return nil, err
+			}
+			s.SetPasswordStore(cfg.Passwords)
+			return s, nil
+		},
+	})
+}
+
+func realmOf(cfg *sasl.Config) string {
+	if cfg.Realm != "" {
+		return cfg.Realm
+	}
+	return cfg.Host
+}
@@ -19,11 +19,18 @@ type response struct {
 	ok                                     bool
 	hpassword                              []byte
 	nonce_count                            int
+	maxbuf                                 int    // Maximal size of security layer buffer client accepts, 0 means default
+	cipher                                 []byte // Cipher selected for auth-conf
 }
 
-func newResponse(opts *Options) *response {
+func newResponse(opts *Options) (*response, error) {
+	cnonce, err := opts.Generator.GetNonce(cnonce_size)
+	if err != nil {
+		return nil, err
+	}
+
 	return &response{
-		cnonce:      opts.Generator.GetNonce(cnonce_size),
+		cnonce:      cnonce,
 		nonce_count: 1, // Need to generate this somehow
 		charset:     []byte(opts.Charset),
 		realm:       []byte(opts.Realm),
@@ -31,7 +38,8 @@ func newResponse(opts *Options) *response {
 		digest_uri:  []byte(opts.DigestURI),
 		server_type: []byte(opts.ServerType),
 		auth_id:     []byte(opts.AuthID),
-	}
+		maxbuf:      opts.MaxBuf,
+	}, nil
 }
 
 func (r *response) nc() []byte {
@@ -51,8 +59,9 @@ func (r *response) parseResponse(data []byte, c *challenge) error {
 	fmap.Add("charset", &(r.charset))
 	fmap.Add("authzid", &(r.auth_id))
 	fmap.Add("qop", &(r.qop))
+	fmap.Add("cipher", &(r.cipher))
 
-	uniq := map[string]int{"username": 0, "realm": 0, "nonce": 0, "cnonce": 0, "nc": 0}
+	uniq := map[string]int{"username": 0, "realm": 0, "nonce": 0, "cnonce": 0, "nc": 0, "maxbuf": 0, "cipher": 0}
 
 	return sasl.EachToken(data, ',', func(token []byte) error {
 		if !bytes.Contains(token, []byte{'='}) {
@@ -75,8 +84,14 @@ func (r *response) parseResponse(data []byte, c *challenge) error {
 				return fmt.Errorf("Wrong nc value: %s", err)
 			}
 			r.nonce_count = v
+		case "maxbuf":
+			v, err := strconv.Atoi(string(val))
+			if err != nil || v <= 0 || v > MAX_MAXBUF {
+				return fmt.Errorf("Wrong maxbuf value: %s", val)
+			}
+			r.maxbuf = v
 		default:
-			if err := fmap.Set(string(key), bytes.Trim(val, "\"")); err != nil {
+			if err := fmap.Set(string(key), unquote(val)); err != nil {
 				return err
 			}
 		}
@@ -116,6 +131,12 @@ func (r *response) response(username []byte, c *challenge) []byte {
 	repl = appendKVQuoted(repl, "serv-type", r.server_type)
 	repl = appendKV(repl, "charset", r.charset)
 	repl = appendKV(repl, "qop", r.qop)
+	if r.maxbuf > 0 {
+		repl = appendKV(repl, "maxbuf", []byte(strconv.Itoa(r.maxbuf)))
+	}
+	if bytes.Equal(r.qop, []byte(QOP_AUTH_CONF)) {
+		repl = appendKV(repl, "cipher", r.cipher)
+	}
 
 	return sasl.MakeMessage(repl...)
 }
@@ -133,7 +154,11 @@ func (r *response) validate(c *challenge) error {
 		return errors.New("Wrong QOP received from client")
 	}
 
-	if !bytes.Equal(r.generateHash(), r.resp) {
+	if bytes.Equal(r.qop, []byte(QOP_AUTH_CONF)) && !sasl.Contains(r.cipher, c.ciphers) {
+		return errors.New("Wrong cipher received from client")
+	}
+
+	if !secureEqual(r.generateHash(), r.resp) {
 		return errors.New("Wrong response hash received")
 	}
 	r.ok = true
@@ -156,12 +181,18 @@ func (r *response) HashPassword(password []byte) []byte {
 	return x[:]
 }
 
-func (r *response) genResponse(method []byte) []byte {
+// Returns H(A1) of md5-sess which binds password hash to nonces and authzid.
+// It is also used to derive security layer keys, so they differ in each session
+func (r *response) a1() [md5.Size]byte {
 	bstart := makeMessage(r.hpassword, r.nonce, r.cnonce)
 	if len(r.auth_id) > 0 {
 		bstart = makeMessage(bstart, r.auth_id)
 	}
-	start := md5.Sum(bstart)
+	return md5.Sum(bstart)
+}
+
+func (r *response) genResponse(method []byte) []byte {
+	start := r.a1()
 	hstart := sasl.BytesToHex(start[:])
 
 	bend := makeMessage(method, r.digest_uri)
@@ -0,0 +1,38 @@
+package digest
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+)
+
+func TestConstantTimeVerification(t *testing.T) {
+	calls := 0
+	secureEqual = func(left, right []byte) bool {
+		calls++
+		return sasl.SecureEqual(left, right)
+	}
+	defer func() { secureEqual = sasl.SecureEqual }()
+
+	opts := &Options{Realms: []string{"example.com"}, DigestURI: "xmpp/example.com"}
+	s, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClientFromChallenge(s.Challenge(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := md5.Sum([]byte("user:example.com:pencil"))
+	if err := s.ParseResponse(c.ResponseHashed("user", hash[:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("pencil"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal("Response should be verified with secureEqual, calls:", calls)
+	}
+}
@@ -0,0 +1,328 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Security layers described in RFC 2831 section 2.3 and 2.4
+
+const (
+	QOP_AUTH      = "auth"
+	QOP_AUTH_INT  = "auth-int"
+	QOP_AUTH_CONF = "auth-conf"
+)
+
+const (
+	DEFAULT_MAXBUF = 65536
+	MAX_MAXBUF     = 16777215
+)
+
+const (
+	KIC_MAGIC = "Digest session key to client-to-server signing key magic constant"
+	KIS_MAGIC = "Digest session key to server-to-client signing key magic constant"
+	KCC_MAGIC = "Digest H(A1) to client-to-server sealing key magic constant"
+	KCS_MAGIC = "Digest H(A1) to server-to-client sealing key magic constant"
+)
+
+const (
+	mac_size      = 10
+	frame_trailer = mac_size + 2 + 4 // MAC, message type and sequence number
+)
+
+// Ciphers offered and accepted for auth-conf by default in order of client's preference
+var Ciphers = []string{"3des", "rc4"}
+
+// Weak ciphers which are used only if they are listed in Options.Ciphers
+var WeakCiphers = []string{"des", "rc4-56", "rc4-40"}
+
+type cipherSpec struct {
+	n   int // Number of H(A1) bytes used to derive key
+	new func(kc []byte, encrypt bool) (*layerCipher, error)
+}
+
+var cipherSpecs = map[string]cipherSpec{
+	"rc4":    {16, newRC4},
+	"rc4-56": {7, newRC4},
+	"rc4-40": {5, newRC4},
+	"des":    {16, newDES},
+	"3des":   {16, new3DES},
+}
+
+type layerCipher struct {
+	stream cipher.Stream
+	block  cipher.BlockMode
+}
+
+func newRC4(kc []byte, encrypt bool) (*layerCipher, error) {
+	c, err := rc4.NewCipher(kc)
+	if err != nil {
+		return nil, err
+	}
+	return &layerCipher{stream: c}, nil
+}
+
+func newDES(kc []byte, encrypt bool) (*layerCipher, error) {
+	b, err := des.NewCipher(desKey(kc[:7]))
+	if err != nil {
+		return nil, err
+	}
+	return newCBC(b, kc[8:], encrypt), nil
+}
+
+// Two key triple DES where the third key is the first one
+func new3DES(kc []byte, encrypt bool) (*layerCipher, error) {
+	k1, k2 := desKey(kc[:7]), desKey(kc[7:14])
+	b, err := des.NewTripleDESCipher(append(append(k1, k2...), k1...))
+	if err != nil {
+		return nil, err
+	}
+	return newCBC(b, kc[8:], encrypt), nil
+}
+
+func newCBC(b cipher.Block, iv []byte, encrypt bool) *layerCipher {
+	if encrypt {
+		return &layerCipher{block: cipher.NewCBCEncrypter(b, iv)}
+	}
+	return &layerCipher{block: cipher.NewCBCDecrypter(b, iv)}
+}
+
+// Expands 56 bits into DES key inserting odd parity bit after each 7 bits
+func desKey(k []byte) []byte {
+	key := []byte{
+		k[0],
+		k[0]<<7 | k[1]>>1,
+		k[1]<<6 | k[2]>>2,
+		k[2]<<5 | k[3]>>3,
+		k[3]<<4 | k[4]>>4,
+		k[4]<<3 | k[5]>>5,
+		k[5]<<2 | k[6]>>6,
+		k[6] << 1,
+	}
+	for i, b := range key {
+		b &^= 1
+		parity := b ^ b>>4
+		parity ^= parity >> 2
+		parity ^= parity >> 1
+		key[i] = b | ^parity&1
+	}
+	return key
+}
+
+// Derives key from H(A1) and magic constant
+func (r *response) key(magic string, n int) []byte {
+	ha1 := r.a1()
+	k := md5.Sum(append(ha1[:n:n], magic...))
+	return k[:]
+}
+
+// State of one direction of security layer
+type layerState struct {
+	ki     []byte
+	seq    uint32
+	cipher *layerCipher
+}
+
+func (s *layerState) mac(msg []byte) []byte {
+	mac := hmac.New(md5.New, s.ki)
+	binary.Write(mac, binary.BigEndian, s.seq)
+	mac.Write(msg)
+	return mac.Sum(nil)[:mac_size]
+}
+
+// Makes frame with length prefix from message
+func (s *layerState) seal(msg []byte) []byte {
+	body := sasl.MakeCopy(msg)
+	if s.cipher != nil && s.cipher.block != nil {
+		pad := des.BlockSize - (len(msg)+mac_size)%des.BlockSize
+		body = append(body, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	}
+	body = append(body, s.mac(msg)...)
+
+	switch {
+	case s.cipher == nil:
+	case s.cipher.stream != nil:
+		s.cipher.stream.XORKeyStream(body, body)
+	case s.cipher.block != nil:
+		s.cipher.block.CryptBlocks(body, body)
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(body)+6))
+	frame = append(frame, body...)
+	frame = binary.BigEndian.AppendUint16(frame, 1)
+	frame = binary.BigEndian.AppendUint32(frame, s.seq)
+	s.seq++
+	return frame
+}
+
+// Verifies frame without length prefix and returns message
+func (s *layerState) open(frame []byte) ([]byte, error) {
+	trailer := frame[len(frame)-6:]
+	if binary.BigEndian.Uint16(trailer) != 1 {
+		return nil, errors.New("Wrong security layer message type")
+	}
+	if binary.BigEndian.Uint32(trailer[2:]) != s.seq {
+		return nil, errors.New("Wrong security layer sequence number")
+	}
+
+	body := frame[:len(frame)-6]
+	switch {
+	case s.cipher == nil:
+	case s.cipher.stream != nil:
+		s.cipher.stream.XORKeyStream(body, body)
+	case s.cipher.block != nil:
+		if len(body)%des.BlockSize != 0 {
+			return nil, errors.New("Wrong security layer message size")
+		}
+		s.cipher.block.CryptBlocks(body, body)
+	}
+
+	msg, mac := body[:len(body)-mac_size], body[len(body)-mac_size:]
+	if s.cipher != nil && s.cipher.block != nil {
+		pad := int(msg[len(msg)-1])
+		if pad == 0 || pad > des.BlockSize || pad > len(msg) || !bytes.Equal(msg[len(msg)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+			return nil, errors.New("Wrong security layer padding")
+		}
+		msg = msg[:len(msg)-pad]
+	}
+
+	if !secureEqual(s.mac(msg), mac) {
+		return nil, errors.New("Wrong security layer MAC")
+	}
+	s.seq++
+	return msg, nil
+}
+
+// Connection protecting data with integrity or confidentiality security layer
+type securityLayer struct {
+	net.Conn
+
+	rmu, wmu   sync.Mutex
+	send, recv *layerState
+	max_send   int    // Maximal size of buffer peer accepts
+	max_recv   int    // Maximal size of buffer accepted from peer
+	overhead   int    // Maximal size of frame added to message
+	rbuf       []byte // Received data not read yet
+}
+
+// Creates security layer for negotiated QOP. Maximal buffer sizes are ones
+// advertised by sending and receiving sides
+func newSecurityLayer(conn net.Conn, r *response, max_send, max_recv int, client bool) (net.Conn, error) {
+	var spec cipherSpec
+	switch string(r.qop) {
+	case "", QOP_AUTH:
+		return conn, nil
+	case QOP_AUTH_INT:
+	case QOP_AUTH_CONF:
+		var ok bool
+		if spec, ok = cipherSpecs[string(r.cipher)]; !ok {
+			return nil, fmt.Errorf("Unsupported cipher: %s", r.cipher)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported QOP: %s", r.qop)
+	}
+
+	l := &securityLayer{
+		Conn:     conn,
+		send:     &layerState{ki: r.key(KIC_MAGIC, md5.Size)},
+		recv:     &layerState{ki: r.key(KIS_MAGIC, md5.Size)},
+		max_send: bufferSize(max_send),
+		max_recv: bufferSize(max_recv),
+		overhead: frame_trailer,
+	}
+	if !client {
+		l.send, l.recv = l.recv, l.send
+	}
+
+	if spec.new != nil {
+		kcc, kcs := r.key(KCC_MAGIC, spec.n), r.key(KCS_MAGIC, spec.n)
+		if !client {
+			kcc, kcs = kcs, kcc
+		}
+
+		var err error
+		if l.send.cipher, err = spec.new(kcc, true); err != nil {
+			return nil, err
+		}
+		if l.recv.cipher, err = spec.new(kcs, false); err != nil {
+			return nil, err
+		}
+		l.overhead += des.BlockSize
+	}
+
+	return l, nil
+}
+
+func bufferSize(maxbuf int) int {
+	if maxbuf <= 0 {
+		return DEFAULT_MAXBUF
+	}
+	return maxbuf
+}
+
+// Reads data received in security layer frames
+func (l *securityLayer) Read(p []byte) (int, error) {
+	l.rmu.Lock()
+	defer l.rmu.Unlock()
+
+	for len(l.rbuf) == 0 {
+		var size [4]byte
+		if _, err := io.ReadFull(l.Conn, size[:]); err != nil {
+			return 0, err
+		}
+
+		n := binary.BigEndian.Uint32(size[:])
+		if n > uint32(l.max_recv) || n < frame_trailer {
+			return 0, fmt.Errorf("Wrong security layer buffer size: %d", n)
+		}
+
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(l.Conn, frame); err != nil {
+			return 0, err
+		}
+
+		msg, err := l.recv.open(frame)
+		if err != nil {
+			return 0, err
+		}
+		l.rbuf = msg
+	}
+
+	n := copy(p, l.rbuf)
+	l.rbuf = l.rbuf[n:]
+	return n, nil
+}
+
+// Writes data splitting it into frames fitting buffer size peer accepts
+func (l *securityLayer) Write(p []byte) (int, error) {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	chunk := l.max_send - l.overhead
+	if chunk <= 0 {
+		return 0, errors.New("Peer's maxbuf is too small")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunk)
+		if _, err := l.Conn.Write(l.send.seal(p[:n])); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,240 @@
+package digest_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/goxmpp/sasl/digest"
+)
+
+// Authenticates client with requested QOP and cipher and returns both sides of wrapped connection
+func newLayers(t *testing.T, qop, cipher string) (net.Conn, net.Conn) {
+	s, err := digest.NewServer(&digest.Options{
+		Realms:    []string{"example.com"},
+		QOPs:      []string{digest.QOP_AUTH, digest.QOP_AUTH_INT, digest.QOP_AUTH_CONF},
+		MaxBuf:    1024,
+		DigestURI: "xmpp/example.com",
+		Ciphers:   append(append([]string{}, digest.Ciphers...), digest.WeakCiphers...),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := digest.NewClientFromChallenge(s.Challenge(), &digest.Options{
+		QOP:       qop,
+		Ciphers:   []string{cipher},
+		MaxBuf:    512,
+		DigestURI: "xmpp/example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ParseResponse(respond(c)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("pencil"); err != nil {
+		t.Fatal(err)
+	}
+
+	cconn, sconn := net.Pipe()
+	t.Cleanup(func() { cconn.Close(); sconn.Close() })
+
+	cwrapped, err := c.Wrap(cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swrapped, err := s.Wrap(sconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cwrapped, swrapped
+}
+
+// Answers challenge as user with password "pencil" in example.com realm
+func respond(c *digest.Client) []byte {
+	hash := md5.Sum([]byte("user:example.com:pencil"))
+	return c.ResponseHashed("user", hash[:])
+}
+
+// Sends data through connection checking it is received intact
+func exchange(t *testing.T, from, to net.Conn, data []byte) {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := from.Write(data)
+		errs <- err
+	}()
+
+	received := make([]byte, len(data))
+	if _, err := io.ReadFull(to, received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("Received data differs from sent one")
+	}
+}
+
+func TestSecurityLayers(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 200) // Larger than maxbuf of both sides
+
+	for _, tc := range []struct{ qop, cipher string }{
+		{digest.QOP_AUTH_INT, ""},
+		{digest.QOP_AUTH_CONF, "rc4"},
+		{digest.QOP_AUTH_CONF, "rc4-56"},
+		{digest.QOP_AUTH_CONF, "rc4-40"},
+		{digest.QOP_AUTH_CONF, "des"},
+		{digest.QOP_AUTH_CONF, "3des"},
+	} {
+		t.Run(tc.qop+" "+tc.cipher, func(t *testing.T) {
+			client, server := newLayers(t, tc.qop, tc.cipher)
+			for i := 0; i < 3; i++ {
+				exchange(t, client, server, data[:len(data)-i*7])
+				exchange(t, server, client, data[:len(data)-i*5])
+			}
+		})
+	}
+}
+
+func TestSecurityLayerAuthOnly(t *testing.T) {
+	s, err := digest.NewServer(&digest.Options{Realms: []string{"example.com"}, DigestURI: "xmpp/example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := digest.NewClientFromChallenge(s.Challenge(), &digest.Options{DigestURI: "xmpp/example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseResponse(respond(c)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("pencil"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	if wrapped, err := s.Wrap(conn); err != nil || wrapped != conn {
+		t.Error("Connection should not be wrapped without security layer", err)
+	}
+}
+
+func TestSecurityLayerTampering(t *testing.T) {
+	s, err := digest.NewServer(&digest.Options{
+		Realms:    []string{"example.com"},
+		QOPs:      []string{digest.QOP_AUTH_INT},
+		DigestURI: "xmpp/example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := digest.NewClientFromChallenge(s.Challenge(), &digest.Options{DigestURI: "xmpp/example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseResponse(respond(c)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("pencil"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Record frame sent by client
+	cconn, raw := net.Pipe()
+	defer cconn.Close()
+	client, err := c.Wrap(cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := io.ReadAtLeast(raw, buf, 4+5+16)
+		frame <- buf[:n]
+	}()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-frame
+
+	// Deliver modified frame to server
+	sconn, peer := net.Pipe()
+	defer sconn.Close()
+	server, err := s.Wrap(sconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent[4] ^= 1
+	go peer.Write(sent)
+	if _, err := server.Read(make([]byte, 16)); err == nil {
+		t.Error("Modified message should be rejected")
+	}
+}
+
+func TestCipherNegotiation(t *testing.T) {
+	s, err := digest.NewServer(&digest.Options{
+		Realms:  []string{"example.com"},
+		QOPs:    []string{digest.QOP_AUTH_CONF},
+		Ciphers: []string{"rc4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := digest.NewClientFromChallenge(s.Challenge(), &digest.Options{Ciphers: []string{"3des"}}); err == nil {
+		t.Error("Client should fail when server doesn't offer acceptable cipher")
+	}
+
+	c, err := digest.NewClientFromChallenge(s.Challenge(), &digest.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := c.Response("user", "pencil")
+	if !bytes.Contains(resp, []byte("qop=auth-conf")) || !bytes.Contains(resp, []byte("cipher=rc4")) {
+		t.Error("Client should select offered QOP and cipher:", string(resp))
+	}
+}
+
+func TestQOPDowngrade(t *testing.T) {
+	for _, challenge := range []string{`nonce="abc",qop="auth"`, `nonce="abc"`} {
+		if _, err := digest.NewClientFromChallenge([]byte(challenge), &digest.Options{QOP: digest.QOP_AUTH_CONF}); err == nil {
+			t.Errorf("Requested auth-conf should not be downgraded by challenge %s", challenge)
+		}
+	}
+
+	c, err := digest.NewClientFromChallenge([]byte(`nonce="abc",qop="auth-conf,auth"`), &digest.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := c.Response("user", "pencil"); !bytes.Contains(resp, []byte("qop=auth,")) && !bytes.HasSuffix(resp, []byte("qop=auth")) {
+		t.Error("Client should prefer auth when no QOP is requested:", string(resp))
+	}
+}
+
+func TestWeakCiphers(t *testing.T) {
+	s, err := digest.NewServer(&digest.Options{QOPs: []string{digest.QOP_AUTH_CONF}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, offered, _ := bytes.Cut(s.Challenge(), []byte(`cipher="`))
+	offered, _, _ = bytes.Cut(offered, []byte(`"`))
+	for _, cipher := range bytes.Split(offered, []byte(",")) {
+		for _, weak := range digest.WeakCiphers {
+			if string(cipher) == weak {
+				t.Errorf("Weak cipher %s should not be offered by default: %s", weak, s.Challenge())
+			}
+		}
+	}
+
+	challenge := []byte(`nonce="abc",qop="auth-conf",cipher="rc4-40,des"`)
+	if _, err := digest.NewClientFromChallenge(challenge, &digest.Options{QOP: digest.QOP_AUTH_CONF}); err == nil {
+		t.Error("Weak ciphers should not be accepted by default")
+	}
+	if _, err := digest.NewClientFromChallenge(challenge, &digest.Options{QOP: digest.QOP_AUTH_CONF, Ciphers: []string{"rc4-40"}}); err != nil {
+		t.Error("Weak cipher should be accepted when listed explicitly:", err)
+	}
+}
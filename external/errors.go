@@ -0,0 +1,37 @@
+package external
+
+import "fmt"
+
+type WrongClientMessage string
+
+func (wcm WrongClientMessage) Error() string {
+	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
+}
+
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
+
+type WrongServerMessage string
+
+func (wsm WrongServerMessage) Error() string {
+	return fmt.Sprintf("Wrong Server Message Provided: %s", string(wsm))
+}
+
+// Returned when client certificate can't be used for authentication
+type CertificateError string
+
+func (ce CertificateError) Error() string {
+	return fmt.Sprintf("Client certificate can't be used: %s", string(ce))
+}
+
+// Returned when authenticated identity is not allowed to act as requested authorization identity
+type NotAuthorized string
+
+func (na NotAuthorized) Error() string {
+	return fmt.Sprintf("Not authorized to act as %s", string(na))
+}
+
+func (na NotAuthorized) InvalidAuthzID() bool {
+	return true
+}
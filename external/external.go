@@ -0,0 +1,164 @@
+// Package implements EXTERNAL mechanism described in RFC 4422 Appendix A
+// using identities of verified TLS client certificate
+package external
+
+import (
+	"bytes"
+	"crypto/tls"
+	"unicode/utf8"
+
+	"github.com/goxmpp/sasl"
+)
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:     "EXTERNAL",
+		Explicit: true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			return NewClient(cfg.AuthID), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			if cfg.TLS == nil {
+				return nil, CertificateError("TLS connection state is required")
+			}
+			return NewServer(cfg.TLS), nil
+		},
+	})
+}
+
+type Client struct {
+	auth_id string
+	step    int
+}
+
+// Creates client optionally requesting auth_id authorization identity
+func NewClient(auth_id string) *Client {
+	return &Client{auth_id: auth_id}
+}
+
+// Implements sasl.ClientMechanism. Authorization identity is sent as initial
+// response or as reply to empty challenge
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		c.step++
+		return []byte(c.auth_id), false, nil
+	case 1:
+		c.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+// Checks if authenticated identities are allowed to act as auth_id
+type Authorizer func(identities []string, auth_id string) error
+
+type Server struct {
+	state      *tls.ConnectionState
+	mapper     IdentityMapper
+	authorizer Authorizer
+	identities []string
+	username   string
+	auth_id    string
+	step       int
+}
+
+// Creates server authenticating client by certificate of TLS connection.
+// Certificate should be verified during handshake, so tls.Config.ClientCAs
+// or VerifyPeerCertificate are expected to be configured
+func NewServer(state *tls.ConnectionState) *Server {
+	return &Server{state: state, mapper: DefaultMapper, authorizer: identityAuthorizer}
+}
+
+// Sets function used to extract identities from client certificate. DefaultMapper is used by default
+func (s *Server) SetIdentityMapper(mapper IdentityMapper) {
+	s.mapper = mapper
+}
+
+// Sets function used to check requested authorization identity.
+// By default authorization identity should match one of certificate's identities
+func (s *Server) SetAuthorizer(authorizer Authorizer) {
+	s.authorizer = authorizer
+}
+
+// Returns requested authorization identity or UserName if none was requested
+func (s *Server) AuthID() string {
+	if s.auth_id != "" {
+		return s.auth_id
+	}
+	return s.username
+}
+
+// Returns authentication identity extracted from client certificate
+func (s *Server) UserName() string {
+	return s.username
+}
+
+// Returns all identities extracted from client certificate
+func (s *Server) Identities() []string {
+	return s.identities
+}
+
+// Implements sasl.ServerMechanism. Response is treated as requested
+// authorization identity, so empty response means no identity was requested
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	if s.step != 0 {
+		return nil, false, WrongClientMessage("Authentication exchange is already completed")
+	}
+
+	if !utf8.Valid(response) {
+		return nil, false, WrongClientMessage("Authorization identity is not valid UTF-8")
+	}
+	if bytes.IndexByte(response, 0) >= 0 {
+		return nil, false, WrongClientMessage("Authorization identity contains NUL character")
+	}
+
+	if err := s.authenticate(); err != nil {
+		return nil, false, err
+	}
+
+	if len(response) > 0 {
+		if err := s.authorizer(s.identities, string(response)); err != nil {
+			return nil, false, err
+		}
+		s.auth_id = string(response)
+	}
+
+	s.step++
+	return nil, true, nil
+}
+
+func (s *Server) authenticate() error {
+	switch {
+	case s.state == nil || !s.state.HandshakeComplete:
+		return CertificateError("TLS handshake is not completed")
+	case len(s.state.PeerCertificates) == 0:
+		return CertificateError("Client didn't provide certificate")
+	case len(s.state.VerifiedChains) == 0:
+		return CertificateError("Client certificate wasn't verified")
+	}
+
+	ids, err := s.mapper(s.state.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return CertificateError("No identity found in client certificate")
+	}
+
+	s.identities, s.username = ids, ids[0]
+	return nil
+}
+
+func identityAuthorizer(identities []string, auth_id string) error {
+	for _, id := range identities {
+		if id == auth_id {
+			return nil
+		}
+	}
+	return NotAuthorized(auth_id)
+}
@@ -0,0 +1,159 @@
+package external
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Builds Subject Alternative Name extension with xmppAddr and DNS name
+func subjectAltName(t *testing.T, xmpp_addr, dns string) pkix.Extension {
+	addr, err := asn1.MarshalWithParams(xmpp_addr, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := asn1.MarshalWithParams(otherName{oidXmppAddr, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: addr}}, "tag:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns_name, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(dns)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := append(other, dns_name...)
+
+	value, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}
+}
+
+func certificate(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl.SerialNumber = big.NewInt(1)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func verified(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		HandshakeComplete: true,
+		PeerCertificates:  []*x509.Certificate{cert},
+		VerifiedChains:    [][]*x509.Certificate{{cert}},
+	}
+}
+
+func TestDefaultMapper(t *testing.T) {
+	cert := certificate(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "ignored"},
+		ExtraExtensions: []pkix.Extension{subjectAltName(t, "juliet@im.example.com", "im.example.com")},
+	})
+
+	ids, err := DefaultMapper(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "juliet@im.example.com" || ids[1] != "im.example.com" {
+		t.Fatal("Wrong identities extracted:", ids)
+	}
+
+	cert = certificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "device-42"}})
+	if ids, err := DefaultMapper(cert); err != nil || len(ids) != 1 || ids[0] != "device-42" {
+		t.Fatal("Common Name should be used if there are no alternative names:", ids, err)
+	}
+
+	cert = certificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ignored"}, EmailAddresses: []string{"romeo@example.net"}})
+	if ids, err := DefaultMapper(cert); err != nil || len(ids) != 1 || ids[0] != "romeo@example.net" {
+		t.Fatal("Email address should be extracted:", ids, err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	cert := certificate(t, &x509.Certificate{
+		ExtraExtensions: []pkix.Extension{subjectAltName(t, "juliet@im.example.com", "im.example.com")},
+	})
+
+	for auth_id, expected := range map[string]string{"": "juliet@im.example.com", "im.example.com": "im.example.com"} {
+		c, s := NewClient(auth_id), NewServer(verified(cert))
+
+		resp, done, err := c.Step(nil)
+		if err != nil || done {
+			t.Fatal("Client should send initial response", err)
+		}
+		if _, done, err := s.Step(resp); err != nil || !done {
+			t.Fatal("Authentication failed", err)
+		}
+		if _, done, err := c.Step(nil); err != nil || !done {
+			t.Fatal("Client should complete authentication", err)
+		}
+
+		if s.UserName() != "juliet@im.example.com" || s.AuthID() != expected {
+			t.Fatal("Wrong identity authenticated:", s.UserName(), s.AuthID())
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	cert := certificate(t, &x509.Certificate{DNSNames: []string{"device.example.com"}})
+
+	s := NewServer(verified(cert))
+	if _, _, err := s.Step([]byte("admin@example.com")); err == nil {
+		t.Fatal("Authorization identity not matching certificate should be rejected")
+	}
+
+	s = NewServer(verified(cert))
+	s.SetAuthorizer(func(ids []string, auth_id string) error { return nil })
+	if _, done, err := s.Step([]byte("admin@example.com")); err != nil || !done || s.AuthID() != "admin@example.com" {
+		t.Fatal("Authorizer should allow authorization identity", err)
+	}
+
+	s = NewServer(verified(cert))
+	s.SetIdentityMapper(func(cert *x509.Certificate) ([]string, error) { return []string{"mapped"}, nil })
+	if _, _, err := s.Step(nil); err != nil || s.UserName() != "mapped" {
+		t.Fatal("Identity mapper should be used", err)
+	}
+}
+
+func TestUnverifiedCertificate(t *testing.T) {
+	cert := certificate(t, &x509.Certificate{DNSNames: []string{"device.example.com"}})
+
+	for _, state := range []*tls.ConnectionState{
+		{HandshakeComplete: true},
+		{HandshakeComplete: true, PeerCertificates: []*x509.Certificate{cert}},
+		{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}},
+	} {
+		if _, _, err := NewServer(state).Step(nil); err == nil {
+			t.Fatal("Authentication without verified certificate should fail")
+		}
+	}
+}
+
+func TestUnexpectedChallenge(t *testing.T) {
+	if _, _, err := NewClient("").Step([]byte("challenge")); err == nil {
+		t.Fatal("Non-empty challenge should be rejected")
+	} else if _, ok := err.(WrongServerMessage); !ok {
+		t.Fatal("Server's message error expected:", err)
+	}
+}
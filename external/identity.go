@@ -0,0 +1,86 @@
+package external
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// id-on-xmppAddr, see RFC 6120 section 13.7.1.4
+	oidXmppAddr = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 8, 5}
+)
+
+// Returns identities client certificate was issued for.
+// Identities are checked in order, the first one is used as authentication identity
+type IdentityMapper func(cert *x509.Certificate) ([]string, error)
+
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue // [0] EXPLICIT wrapped value
+}
+
+// Default IdentityMapper. Returns SAN xmppAddr, DNS names and email addresses
+// of certificate. Common Name is used only if certificate has none of them
+func DefaultMapper(cert *x509.Certificate) ([]string, error) {
+	ids, err := XmppAddrs(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+
+	if len(ids) == 0 && cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return ids, nil
+}
+
+// Returns xmppAddr values of certificate's Subject Alternative Name extension
+func XmppAddrs(cert *x509.Certificate) ([]string, error) {
+	var addrs []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var seq asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+			return nil, err
+		} else if len(rest) != 0 || !seq.IsCompound || seq.Tag != asn1.TagSequence {
+			return nil, CertificateError("Malformed Subject Alternative Name extension")
+		}
+
+		for data := seq.Bytes; len(data) > 0; {
+			var name asn1.RawValue
+			var err error
+			if data, err = asn1.Unmarshal(data, &name); err != nil {
+				return nil, err
+			}
+
+			// otherName is [0] of GeneralName
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			var other otherName
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil {
+				return nil, err
+			}
+			if !other.TypeID.Equal(oidXmppAddr) {
+				continue
+			}
+
+			var addr string
+			if other.Value.Class != asn1.ClassContextSpecific || other.Value.Tag != 0 {
+				return nil, CertificateError("Malformed xmppAddr")
+			}
+			if _, err := asn1.UnmarshalWithParams(other.Value.Bytes, &addr, "utf8"); err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
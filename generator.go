@@ -2,50 +2,72 @@ package sasl
 
 import (
 	"crypto/rand"
-	mrand "math/rand"
-	"time"
+	"io"
 )
 
-const (
-	MIN_ITERATIONS = 4096
-	MAX_ITERATIONS = 10000
-)
+const MIN_ITERATIONS = 4096
+
+// Deprecated: iterations count is no longer random, it is set with Generator.Iterations
+const MAX_ITERATIONS = 10000
 
 type NonceGenerator interface {
 	// Method used in CNonce and Nonce generation
-	GetNonce(int) []byte
+	GetNonce(int) ([]byte, error)
 }
 
 type SaltGenerator interface {
 	NonceGenerator
 	// Salt derivation function
-	GetSalt(int) []byte
+	GetSalt(int) ([]byte, error)
 	// Iterations count derivation function
 	GetIterations() int
 }
 
-type Generator struct{}
+// Generator reading random data from provided source.
+// Zero value is ready to use and reads from crypto/rand
+type Generator struct {
+	Rand       io.Reader // Source of random data. crypto/rand.Reader is used if nil
+	Iterations int       // Iterations count for new salted passwords. MIN_ITERATIONS is used if less
+}
+
+// Creates generator reading random data from provided source
+// and using provided iterations count
+func NewGenerator(rnd io.Reader, iterations int) *Generator {
+	return &Generator{Rand: rnd, Iterations: iterations}
+}
 
 // Generate nonce and returns it as string
-func (g Generator) GetNonce(size int) []byte {
-	nonce := make([]byte, size)
-	if _, err := rand.Read(nonce); err != nil {
-		panic(err)
+func (g Generator) GetNonce(size int) ([]byte, error) {
+	nonce, err := g.read(size)
+	if err != nil {
+		return nil, err
 	}
-	return Base64ToBytes(nonce)
+	return Base64ToBytes(nonce), nil
 }
 
 // Generates Salt and returns is as slice of bytes
-func (g Generator) GetSalt(size int) []byte {
-	salt := make([]byte, size)
-	if _, err := rand.Read(salt); err != nil {
-		panic(err)
-	}
-	return salt
+func (g Generator) GetSalt(size int) ([]byte, error) {
+	return g.read(size)
 }
 
-// Generates Iterations count. RFC5802 requires minimum number of iterations to be at least 4096 to be secure
+// Returns Iterations count configured for generator.
+// RFC5802 requires minimum number of iterations to be at least 4096 to be secure
 func (g Generator) GetIterations() int {
-	mrand.Seed(time.Now().UnixNano())
-	return MIN_ITERATIONS + mrand.Intn(MAX_ITERATIONS-MIN_ITERATIONS)
+	if g.Iterations < MIN_ITERATIONS {
+		return MIN_ITERATIONS
+	}
+	return g.Iterations
+}
+
+func (g Generator) read(size int) ([]byte, error) {
+	rnd := g.Rand
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(rnd, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
@@ -0,0 +1,44 @@
+package sasl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGeneratorRand(t *testing.T) {
+	g := NewGenerator(bytes.NewReader([]byte("0123456789abcdef")), 0)
+
+	salt, err := g.GetSalt(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(salt) != "0123" {
+		t.Fatal("Salt should be read from provided source, got", string(salt))
+	}
+
+	nonce, err := g.GetNonce(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(nonce) != "NDU2" {
+		t.Fatal("Nonce should be Base64 encoded data from provided source, got", string(nonce))
+	}
+
+	if _, err := g.GetSalt(32); err == nil {
+		t.Fatal("Exhausted source should produce error instead of short salt")
+	}
+}
+
+func TestGeneratorIterations(t *testing.T) {
+	if it := (Generator{}).GetIterations(); it != MIN_ITERATIONS {
+		t.Fatal("Default iterations count should be MIN_ITERATIONS, got", it)
+	}
+
+	if it := NewGenerator(nil, 1000).GetIterations(); it != MIN_ITERATIONS {
+		t.Fatal("Iterations count should not be less than MIN_ITERATIONS, got", it)
+	}
+
+	if it := NewGenerator(nil, 20000).GetIterations(); it != 20000 {
+		t.Fatal("Configured iterations count should be used, got", it)
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/goxmpp/sasl
+
+go 1.24
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,40 @@
+package ht
+
+import "fmt"
+
+type WrongClientMessage string
+
+func (wcm WrongClientMessage) Error() string {
+	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
+}
+
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
+
+type WrongServerMessage string
+
+func (wsm WrongServerMessage) Error() string {
+	return fmt.Sprintf("Wrong Server Message Provided: %s", string(wsm))
+}
+
+// Returned when no valid token of the user matches client's message
+type InvalidToken string
+
+func (it InvalidToken) Error() string {
+	return fmt.Sprintf("No valid token for user %s", string(it))
+}
+
+// Returned when counter provided by client isn't greater than the last one accepted
+type ReplayedCounter uint64
+
+func (rc ReplayedCounter) Error() string {
+	return fmt.Sprintf("Counter %d was already used", uint64(rc))
+}
+
+// Returned when server mechanism is created from config without token store
+type StoreRequired string
+
+func (sr StoreRequired) Error() string {
+	return fmt.Sprintf("Token store is required by %s", string(sr))
+}
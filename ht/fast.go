@@ -0,0 +1,40 @@
+package ht
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Namespace of XEP-0484 elements
+const FAST_NS = "urn:xmpp:fast:0"
+
+// Stream feature listing mechanisms server can issue tokens for
+type Fast struct {
+	XMLName    xml.Name `xml:"urn:xmpp:fast:0 fast"`
+	Mechanisms []string `xml:"mechanism"`
+}
+
+// Inline element of SASL2 authenticate sent by client authenticating with token
+type FastRequest struct {
+	XMLName    xml.Name `xml:"urn:xmpp:fast:0 fast"`
+	Count      uint64   `xml:"count,attr"`
+	Invalidate bool     `xml:"invalidate,attr,omitempty"`
+}
+
+// Inline element of SASL2 authenticate requesting new token
+type RequestToken struct {
+	XMLName   xml.Name `xml:"urn:xmpp:fast:0 request-token"`
+	Mechanism string   `xml:"mechanism,attr"`
+}
+
+// Sent by server within SASL2 success
+type TokenElement struct {
+	XMLName xml.Name  `xml:"urn:xmpp:fast:0 token"`
+	Expiry  time.Time `xml:"expiry,attr"`
+	Token   string    `xml:"token,attr"`
+}
+
+// Creates element delivering token to client
+func NewTokenElement(token *Token) *TokenElement {
+	return &TokenElement{Expiry: token.Expiry.UTC(), Token: string(token.Secret)}
+}
@@ -0,0 +1,232 @@
+// Package implements HT (Hashed Token) mechanisms described in
+// draft-schmaus-kitten-sasl-ht and used by XEP-0484 FAST
+package ht
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"strings"
+
+	"github.com/goxmpp/sasl"
+)
+
+const (
+	INITIATOR = "Initiator"
+	RESPONDER = "Responder"
+)
+
+// Channel binding types of mechanisms by their names. NONE mechanism doesn't use binding
+var Mechanisms = map[string]string{
+	"HT-SHA-256-NONE": "",
+	"HT-SHA-256-ENDP": sasl.TLS_SERVER_END_POINT,
+	"HT-SHA-256-UNIQ": sasl.TLS_UNIQUE,
+	"HT-SHA-256-EXPR": sasl.TLS_EXPORTER,
+}
+
+func init() {
+	for name, cb_type := range Mechanisms {
+		sasl.Register(newMechanism(name, cb_type))
+	}
+}
+
+func newMechanism(name, cb_type string) *sasl.Mechanism {
+	return &sasl.Mechanism{
+		Name:           name,
+		HashBits:       256,
+		ChannelBinding: cb_type != "",
+		Explicit:       true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			// Token is used instead of password
+			return NewClient(name, cfg.Username, cfg.Password, findBinding(cfg.ChannelBindings, cb_type))
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			issuer := issuerOf(cfg)
+			if issuer == nil {
+				return nil, StoreRequired(name)
+			}
+			s, err := NewServer(name, issuer, findBinding(cfg.ChannelBindings, cb_type))
+			if err != nil {
+				return nil, err
+			}
+			s.SetCounter(cfg.Counter)
+			return s, nil
+		},
+	}
+}
+
+// Returns issuer validating tokens of config, nil if config has no token store
+func issuerOf(cfg *sasl.Config) *Issuer {
+	switch tokens := cfg.Tokens.(type) {
+	case *Issuer:
+		return tokens
+	case TokenStore:
+		return NewIssuer(tokens, cfg.Generator)
+	}
+	// Passwords store may keep tokens too
+	if store, ok := cfg.Passwords.(TokenStore); ok {
+		return NewIssuer(store, cfg.Generator)
+	}
+	return nil
+}
+
+func findBinding(cbs []*sasl.ChannelBinding, cb_type string) *sasl.ChannelBinding {
+	for _, cb := range cbs {
+		if cb.Type == cb_type {
+			return cb
+		}
+	}
+	return nil
+}
+
+// Returns channel binding data mechanism requires
+func bindingData(name string, cb *sasl.ChannelBinding) ([]byte, error) {
+	cb_type, ok := Mechanisms[name]
+	switch {
+	case !ok:
+		return nil, sasl.UnknownMechanism(name)
+	case cb_type == "":
+		return nil, nil
+	case cb == nil || cb.Type != cb_type:
+		return nil, sasl.UnsupportedChannelBinding(name)
+	}
+	return cb.Data, nil
+}
+
+func hashConstructor(name string) func() hash.Hash {
+	if strings.HasPrefix(name, "HT-SHA-256-") {
+		return sha256.New
+	}
+	return nil
+}
+
+// Calculates HMAC of token with label and channel binding data
+func hashedToken(cons func() hash.Hash, token []byte, label string, cb_data []byte) []byte {
+	mac := hmac.New(cons, token)
+	mac.Write([]byte(label))
+	mac.Write(cb_data)
+	return mac.Sum(nil)
+}
+
+type Client struct {
+	cons     func() hash.Hash
+	username string
+	token    []byte
+	cb_data  []byte
+	step     int
+}
+
+// Creates client of named mechanism. Channel binding of type required by mechanism should be provided
+func NewClient(name, username, token string, cb *sasl.ChannelBinding) (*Client, error) {
+	cb_data, err := bindingData(name, cb)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cons: hashConstructor(name), username: username, token: []byte(token), cb_data: cb_data}, nil
+}
+
+// Generates client's message containing user name and initiator hashed token
+func (c *Client) Response() []byte {
+	msg := append([]byte(c.username), 0)
+	return append(msg, hashedToken(c.cons, c.token, INITIATOR, c.cb_data)...)
+}
+
+// Checks responder hashed token sent by server
+func (c *Client) CheckFinal(final []byte) error {
+	if !sasl.SecureEqual(hashedToken(c.cons, c.token, RESPONDER, c.cb_data), final) {
+		return WrongServerMessage("Wrong responder hashed token")
+	}
+	return nil
+}
+
+// Implements sasl.ClientMechanism
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		c.step++
+		return c.Response(), false, nil
+	case 1:
+		if err := c.CheckFinal(challenge); err != nil {
+			return nil, false, err
+		}
+		c.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+type Server struct {
+	name     string
+	cons     func() hash.Hash
+	issuer   *Issuer
+	cb_data  []byte
+	count    uint64
+	username string
+	token    *Token
+	step     int
+}
+
+// Creates server of named mechanism validating tokens with issuer.
+// Channel binding of type required by mechanism should be provided
+func NewServer(name string, issuer *Issuer, cb *sasl.ChannelBinding) (*Server, error) {
+	cb_data, err := bindingData(name, cb)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{name: name, cons: hashConstructor(name), issuer: issuer, cb_data: cb_data}, nil
+}
+
+// Sets counter sent by client, e.g. count attribute of XEP-0484 fast element.
+// Counter should grow with every authentication attempt, so replayed messages are rejected
+func (s *Server) SetCounter(count uint64) {
+	s.count = count
+}
+
+// HT doesn't support authorization identity, so UserName is returned
+func (s *Server) AuthID() string {
+	return s.username
+}
+
+// Returns UserName provided by client
+func (s *Server) UserName() string {
+	return s.username
+}
+
+// Returns token client authenticated with
+func (s *Server) Token() *Token {
+	return s.token
+}
+
+// Implements sasl.ServerMechanism. Responder hashed token is returned
+// as additional data when client is authenticated
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	if s.step != 0 {
+		return nil, false, WrongClientMessage("Authentication exchange is already completed")
+	}
+	if len(response) == 0 {
+		// Client didn't send initial response, ask for it with empty challenge
+		return []byte{}, false, nil
+	}
+	s.step++
+
+	sep := bytes.IndexByte(response, 0)
+	if sep <= 0 || sep == len(response)-1 {
+		return nil, false, WrongClientMessage("Message should contain user name and hashed token")
+	}
+	username, initiator := string(response[:sep]), response[sep+1:]
+
+	token, err := s.issuer.Validate(username, s.name, s.count, func(secret []byte) bool {
+		return sasl.SecureEqual(hashedToken(s.cons, secret, INITIATOR, s.cb_data), initiator)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.username, s.token = username, token
+	return hashedToken(s.cons, token.Secret, RESPONDER, s.cb_data), true, nil
+}
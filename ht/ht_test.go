@@ -0,0 +1,182 @@
+package ht
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/goxmpp/sasl"
+)
+
+var exporter = &sasl.ChannelBinding{Type: sasl.TLS_EXPORTER, Data: []byte("exported keying material")}
+
+// Runs exchange between client and server returning server's error
+func exchange(t *testing.T, c *Client, s *Server) error {
+	resp, _, err := c.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	final, done, err := s.Step(resp)
+	if err != nil {
+		return err
+	}
+	if !done {
+		t.Fatal("Server should complete authentication")
+	}
+
+	if _, done, err := c.Step(final); err != nil || !done {
+		t.Fatal("Client should verify responder hashed token", err)
+	}
+	return nil
+}
+
+func TestHashedToken(t *testing.T) {
+	c, err := NewClient("HT-SHA-256-EXPR", "juliet", "s3cr3tt0k3n", exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cr3tt0k3n"))
+	mac.Write([]byte("Initiator"))
+	mac.Write(exporter.Data)
+	expected := append([]byte("juliet\x00"), mac.Sum(nil)...)
+
+	if !hmac.Equal(c.Response(), expected) {
+		t.Fatalf("Wrong response generated: %x", c.Response())
+	}
+}
+
+func TestExchange(t *testing.T) {
+	store := NewMemoryStore()
+	issuer := NewIssuer(store, nil)
+
+	for name, cb := range map[string]*sasl.ChannelBinding{"HT-SHA-256-NONE": nil, "HT-SHA-256-EXPR": exporter} {
+		token, err := issuer.Issue("juliet", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, _ := NewClient(name, "juliet", string(token.Secret), cb)
+		s, _ := NewServer(name, issuer, cb)
+		s.SetCounter(1)
+
+		if err := exchange(t, c, s); err != nil {
+			t.Fatal(name, "authentication failed:", err)
+		}
+		if s.UserName() != "juliet" || s.Token().Count != 1 {
+			t.Fatal(name, "wrong token used:", s.UserName(), s.Token())
+		}
+	}
+
+	// Token issued for other mechanism or bound to other channel is rejected
+	token, _ := issuer.Issue("romeo", "HT-SHA-256-NONE")
+	c, _ := NewClient("HT-SHA-256-EXPR", "romeo", string(token.Secret), exporter)
+	s, _ := NewServer("HT-SHA-256-EXPR", issuer, exporter)
+	s.SetCounter(1)
+	if _, _, err := s.Step(c.Response()); err == nil {
+		t.Fatal("Token of other mechanism should be rejected")
+	}
+
+	if _, err := NewClient("HT-SHA-256-UNIQ", "romeo", "token", exporter); err == nil {
+		t.Fatal("Channel binding of wrong type should be rejected")
+	}
+	if _, err := NewClient("HT-SHA-256-ENDP", "romeo", "token", nil); err == nil {
+		t.Fatal("Channel binding should be required")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	issuer := NewIssuer(NewMemoryStore(), nil)
+	token, _ := issuer.Issue("juliet", "HT-SHA-256-NONE")
+
+	c, _ := NewClient("HT-SHA-256-NONE", "juliet", string(token.Secret), nil)
+	resp := c.Response()
+
+	s, _ := NewServer("HT-SHA-256-NONE", issuer, nil)
+	s.SetCounter(5)
+	if _, _, err := s.Step(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, count := range []uint64{5, 4} {
+		s, _ = NewServer("HT-SHA-256-NONE", issuer, nil)
+		s.SetCounter(count)
+		if _, _, err := s.Step(resp); err != ReplayedCounter(count) {
+			t.Fatal("Replayed counter should be rejected:", err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	store := NewMemoryStore()
+	issuer := NewIssuer(store, nil)
+
+	old, _ := issuer.Issue("juliet", "HT-SHA-256-NONE")
+	fresh, _ := issuer.Issue("juliet", "HT-SHA-256-NONE")
+
+	authenticate := func(token *Token, count uint64) error {
+		c, _ := NewClient("HT-SHA-256-NONE", "juliet", string(token.Secret), nil)
+		s, _ := NewServer("HT-SHA-256-NONE", issuer, nil)
+		s.SetCounter(count)
+		return exchange(t, c, s)
+	}
+
+	// Both tokens are valid until the new one is used
+	if err := authenticate(old, 1); err != nil {
+		t.Fatal("Previous token should be valid during rotation:", err)
+	}
+	if err := authenticate(fresh, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := authenticate(old, 3); err == nil {
+		t.Fatal("Previous token should be invalidated after new one was used")
+	}
+
+	issuer.Lifetime = -time.Second
+	expired, _ := issuer.Issue("juliet", "HT-SHA-256-NONE")
+	if err := authenticate(expired, 4); err == nil {
+		t.Fatal("Expired token should be rejected")
+	}
+
+	if err := issuer.Invalidate("juliet"); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := store.Tokens("juliet"); len(tokens) != 0 {
+		t.Fatal("All tokens should be invalidated")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	store := NewMemoryStore()
+	issuer := NewIssuer(store, nil)
+	token, _ := issuer.Issue("juliet", "HT-SHA-256-NONE")
+
+	authenticate := func(tokens interface{}, count uint64) error {
+		c, err := sasl.NewClient("HT-SHA-256-NONE", &sasl.Config{Username: "juliet", Password: string(token.Secret)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := sasl.NewServer("HT-SHA-256-NONE", &sasl.Config{Tokens: tokens, Counter: count})
+		if err != nil {
+			return err
+		}
+		resp, _, _ := c.Step(nil)
+		_, _, err = s.Step(resp)
+		return err
+	}
+
+	if err := authenticate(store, 1); err != nil {
+		t.Fatal("Server should validate tokens of store with counter from config:", err)
+	}
+	if err := authenticate(issuer, 2); err != nil {
+		t.Fatal("Server should validate tokens with issuer from config:", err)
+	}
+	if err := authenticate(issuer, 2); err != ReplayedCounter(2) {
+		t.Fatal("Replayed counter should be rejected:", err)
+	}
+	if err := authenticate(nil, 3); err != StoreRequired("HT-SHA-256-NONE") {
+		t.Fatal("Token store should be required:", err)
+	}
+}
@@ -0,0 +1,200 @@
+package ht
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/goxmpp/sasl"
+)
+
+const TOKEN_BYTES = 32
+
+// Default lifetime of issued tokens
+const TOKEN_LIFETIME = 14 * 24 * time.Hour
+
+var DefaultGenerator sasl.Generator
+
+// Token issued to client
+type Token struct {
+	Mechanism string    // Mechanism token may be used with
+	Secret    []byte    // Token value sent to client
+	Expiry    time.Time // Token can't be used after it
+	Count     uint64    // The last counter accepted with token
+}
+
+// Persistent storage of issued tokens
+type TokenStore interface {
+	// Returns tokens of the user, the newest one last
+	Tokens(username string) ([]*Token, error)
+	// Saves new token or updates existing one with same Secret
+	SaveToken(username string, token *Token) error
+	DeleteToken(username string, token *Token) error
+}
+
+// Issues and validates tokens. During rotation both current and new
+// tokens are valid until client authenticates with the new one
+type Issuer struct {
+	store    TokenStore
+	gen      sasl.NonceGenerator
+	Lifetime time.Duration // Lifetime of issued tokens, TOKEN_LIFETIME by default
+
+	mu sync.Mutex // Serializes counter checks and rotation
+}
+
+// Creates issuer storing tokens in store. nil can be provided as generator - then default generator will be used
+func NewIssuer(store TokenStore, gen sasl.NonceGenerator) *Issuer {
+	if gen == nil {
+		gen = DefaultGenerator
+	}
+	return &Issuer{store: store, gen: gen, Lifetime: TOKEN_LIFETIME}
+}
+
+// Issues new token for user. Tokens older than previous one are deleted,
+// so the previous token stays valid until the new one is used
+func (i *Issuer) Issue(username, mechanism string) (*Token, error) {
+	secret, err := i.gen.GetNonce(TOKEN_BYTES)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tokens, err := i.tokens(username, mechanism)
+	if err != nil {
+		return nil, err
+	}
+	for n := 0; n < len(tokens)-1; n++ {
+		if err := i.store.DeleteToken(username, tokens[n]); err != nil {
+			return nil, err
+		}
+	}
+
+	token := &Token{Mechanism: mechanism, Secret: secret, Expiry: time.Now().Add(i.Lifetime)}
+	if err := i.store.SaveToken(username, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Invalidates all tokens of the user
+func (i *Issuer) Invalidate(username string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tokens, err := i.store.Tokens(username)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := i.store.DeleteToken(username, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finds valid token of mechanism matching check and accepts count if it's greater
+// than the last one used with token. Using the newest token completes rotation
+// and deletes older tokens
+func (i *Issuer) Validate(username, mechanism string, count uint64, check func(secret []byte) bool) (*Token, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tokens, err := i.tokens(username, mechanism)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, token := range tokens {
+		if time.Now().After(token.Expiry) || !check(token.Secret) {
+			continue
+		}
+
+		if count <= token.Count {
+			return nil, ReplayedCounter(count)
+		}
+		token.Count = count
+		if err := i.store.SaveToken(username, token); err != nil {
+			return nil, err
+		}
+
+		if n == len(tokens)-1 {
+			for _, old := range tokens[:n] {
+				if err := i.store.DeleteToken(username, old); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return token, nil
+	}
+	return nil, InvalidToken(username)
+}
+
+// Returns tokens of the user issued for mechanism
+func (i *Issuer) tokens(username, mechanism string) ([]*Token, error) {
+	all, err := i.store.Tokens(username)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*Token
+	for _, token := range all {
+		if token.Mechanism == mechanism {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// TokenStore keeping tokens in memory
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string][]*Token
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string][]*Token)}
+}
+
+func (ms *MemoryStore) Tokens(username string) ([]*Token, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tokens := make([]*Token, 0, len(ms.tokens[username]))
+	for _, token := range ms.tokens[username] {
+		copied := *token
+		tokens = append(tokens, &copied)
+	}
+	return tokens, nil
+}
+
+func (ms *MemoryStore) SaveToken(username string, token *Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	copied := *token
+	for n, t := range ms.tokens[username] {
+		if bytes.Equal(t.Secret, token.Secret) {
+			ms.tokens[username][n] = &copied
+			return nil
+		}
+	}
+	ms.tokens[username] = append(ms.tokens[username], &copied)
+	return nil
+}
+
+func (ms *MemoryStore) DeleteToken(username string, token *Token) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	tokens := ms.tokens[username]
+	for n, t := range tokens {
+		if bytes.Equal(t.Secret, token.Secret) {
+			ms.tokens[username] = append(tokens[:n:n], tokens[n+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package httpscram
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/scram"
+)
+
+// http.RoundTripper authenticating requests with SCRAM when server asks for it.
+// Requests with body should have GetBody set, so they can be resent
+type Transport struct {
+	Base      http.RoundTripper // Transport used to send requests. http.DefaultTransport is used if nil
+	Username  string
+	Password  string
+	Variant   *scram.Variant     // SCRAM variant, SCRAM-SHA-256 is used if nil
+	Generator sasl.SaltGenerator // optional, nil means default generator
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) variant() *scram.Variant {
+	if t.Variant != nil {
+		return t.Variant
+	}
+	return scram.SHA_256
+}
+
+// Sends request and performs SCRAM exchange if server responds with SCRAM challenge.
+// Error is returned if server's Authentication-Info can't be verified
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := t.variant().Name
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge, ok := findChallenge(resp, scheme)
+	if !ok {
+		return resp, nil
+	}
+	discard(resp)
+
+	c := t.variant().NewClient(t.Generator)
+	c.SetCredentials(t.Username, t.Password)
+
+	first, _, err := c.Step(nil)
+	if err != nil {
+		return nil, err
+	}
+	params := []string{"data", base64.StdEncoding.EncodeToString(first)}
+	if realm, ok := challenge["realm"]; ok {
+		params = append([]string{"realm", realm}, params...)
+	}
+
+	if resp, err = t.send(req, formatHeader(scheme, params...)); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge, ok = findChallenge(resp, scheme)
+	if !ok || challenge["sid"] == "" {
+		// Server rejected Client First message
+		return resp, nil
+	}
+	discard(resp)
+
+	sfirst, err := base64.StdEncoding.DecodeString(challenge["data"])
+	if err != nil {
+		return nil, err
+	}
+	final, _, err := c.Step(sfirst)
+	if err != nil {
+		return nil, err
+	}
+
+	sid := challenge["sid"]
+	if resp, err = t.send(req, formatHeader(scheme, "sid", sid, "data", base64.StdEncoding.EncodeToString(final))); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	if err := verify(c, resp, sid); err != nil {
+		discard(resp)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Checks Server Final message of Authentication-Info header
+func verify(c *scram.Client, resp *http.Response, sid string) error {
+	info := parseParams(resp.Header.Get("Authentication-Info"))
+	if info["sid"] != sid {
+		return WrongServerResponse("Authentication-Info of session is missing")
+	}
+
+	sfinal, err := base64.StdEncoding.DecodeString(info["data"])
+	if err != nil {
+		return err
+	}
+	if _, done, err := c.Step(sfinal); err != nil {
+		return err
+	} else if !done {
+		return WrongServerResponse("Authentication is not completed")
+	}
+	return nil
+}
+
+// Sends copy of request with Authorization header
+func (t *Transport) send(req *http.Request, authorization string) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, WrongServerResponse("Request body can't be resent for authentication")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	r.Header.Set("Authorization", authorization)
+	return t.base().RoundTrip(r)
+}
+
+// Returns parameters of SCRAM challenge among WWW-Authenticate headers
+func findChallenge(resp *http.Response, scheme string) (map[string]string, bool) {
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		// Several challenges may be listed in one header
+		for _, part := range splitChallenges(header) {
+			if params, ok := parseHeader(part, scheme); ok {
+				return params, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Splits header containing several challenges at the start of each scheme
+func splitChallenges(header string) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case ',':
+			if quoted {
+				continue
+			}
+			// Next challenge starts with scheme followed by space, not by '='
+			next := strings.TrimLeft(header[i+1:], " ")
+			sp, eq := strings.IndexByte(next, ' '), strings.IndexByte(next, '=')
+			if sp > 0 && (eq < 0 || sp < eq) {
+				parts = append(parts, header[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, header[start:])
+}
+
+func discard(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,9 @@
+package httpscram
+
+import "fmt"
+
+type WrongServerResponse string
+
+func (wsr WrongServerResponse) Error() string {
+	return fmt.Sprintf("Wrong Server Response Provided: %s", string(wsr))
+}
@@ -0,0 +1,85 @@
+package httpscram
+
+import (
+	"strings"
+)
+
+// Parses credentials or challenge in `scheme param=value, param="quoted value"` form.
+// Returns false if header uses other scheme
+func parseHeader(header, scheme string) (map[string]string, bool) {
+	header = strings.TrimSpace(header)
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return nil, false
+	}
+	rest := header[len(scheme):]
+	if rest != "" && rest[0] != ' ' {
+		return nil, false
+	}
+
+	return parseParams(rest), true
+}
+
+// Parses comma separated parameters with token or quoted string values
+func parseParams(rest string) map[string]string {
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			value, rest = unquote(rest[1:])
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		params[key] = value
+
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return params
+}
+
+// Returns value of quoted string without opening quote and the rest of header
+func unquote(s string) (string, string) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Formats header value from scheme and parameters in provided order
+func formatHeader(scheme string, params ...string) string {
+	return scheme + " " + formatParams(params...)
+}
+
+// Formats name/value pairs of parameters quoting values
+func formatParams(params ...string) string {
+	parts := make([]string, 0, len(params)/2)
+	for i := 0; i+1 < len(params); i += 2 {
+		parts = append(parts, params[i]+"="+quote(params[i+1]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,176 @@
+package httpscram
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl/scram"
+)
+
+type credentials map[string]*scram.Credentials
+
+func (c credentials) Credentials(username string) (*scram.Credentials, error) {
+	if creds, ok := c[username]; ok {
+		return creds, nil
+	}
+	return nil, scram.ERR_UNKNOWN_USER
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	creds, err := scram.SHA_256.NewCredentials(nil, []byte("pencil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "hello "+UserName(r)+" "+string(body))
+	}), "testrealm@example.com", credentials{"user": creds})
+
+	return httptest.NewServer(handler)
+}
+
+func TestParseHeader(t *testing.T) {
+	params, ok := parseHeader(`SCRAM-SHA-256 realm="testrealm@example.com", data=biwsbj11c2VyLHI9ck9wck5HZndFYmVSV2diTkVrcU8K`, "SCRAM-SHA-256")
+	if !ok || params["realm"] != "testrealm@example.com" || params["data"] != "biwsbj11c2VyLHI9ck9wck5HZndFYmVSV2diTkVrcU8K" {
+		t.Fatal("Wrong parameters parsed:", params)
+	}
+
+	if _, ok := parseHeader(`SCRAM-SHA-1 realm="x"`, "SCRAM-SHA-256"); ok {
+		t.Fatal("Other scheme should not be parsed")
+	}
+	if _, ok := parseHeader(`SCRAM-SHA-256-PLUS realm="x"`, "SCRAM-SHA-256"); ok {
+		t.Fatal("Scheme with same prefix should not be parsed")
+	}
+
+	if params := parseParams(`sid=AAAABBBBCCCCDDDD, data="dj0xLz0i\"quoted\""`); params["sid"] != "AAAABBBBCCCCDDDD" || params["data"] != `dj0xLz0i"quoted"` {
+		t.Fatal("Wrong parameters parsed:", params)
+	}
+
+	parts := splitChallenges(`Basic realm="a, b", SCRAM-SHA-256 realm="x", data=abc`)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) != `SCRAM-SHA-256 realm="x", data=abc` {
+		t.Fatalf("Wrong challenges split: %q", parts)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Username: "user", Password: "pencil"}}
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello user world" {
+		t.Fatal("Request should be authenticated:", resp.Status, string(body))
+	}
+	if !strings.Contains(resp.Header.Get("Authentication-Info"), "sid=") {
+		t.Fatal("Authentication-Info should be sent")
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	for _, tr := range []*Transport{{Username: "user", Password: "pen"}, {Username: "nobody", Password: "pencil"}} {
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatal("Wrong credentials should be rejected:", resp.Status)
+		}
+	}
+}
+
+// Replaces Server Final message of Authentication-Info
+type tampering struct{}
+
+func (tampering) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.Header.Get("Authentication-Info") != "" {
+		sid := parseParams(resp.Header.Get("Authentication-Info"))["sid"]
+		resp.Header.Set("Authentication-Info", formatParams("sid", sid, "data", "dj1ybUY5cHFWOFM3c3VBb1pXamE0ZEpSa0ZzS1E9"))
+	}
+	return resp, err
+}
+
+func TestServerVerification(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	_, err := (&http.Client{Transport: &Transport{Base: tampering{}, Username: "user", Password: "pencil"}}).Get(srv.URL)
+	if err == nil {
+		t.Fatal("Wrong server signature should be detected")
+	}
+	var wsm scram.WrongServerMessage
+	if !errors.As(err, &wsm) {
+		t.Fatal("Server signature error expected:", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	srv := newServer(t)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Authorization", formatHeader("SCRAM-SHA-256", "sid", "unknown", "data", "Yz1iaXdz"))
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), `realm="testrealm@example.com"`) {
+		t.Fatal("Unknown session should be rejected with challenge:", resp.Status, resp.Header)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	creds, err := scram.SHA_256.NewCredentials(nil, []byte("pencil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(http.NotFoundHandler(), "testrealm@example.com", credentials{"user": creds})
+	h.max_sessions = 3
+
+	var sids []string
+	for i := 0; i < 5; i++ {
+		c := scram.SHA_256.NewClient(nil)
+		c.SetCredentials("user", "pencil")
+		first, _, err := c.Step(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.first(w, first)
+		params, ok := parseHeader(w.Header().Get("WWW-Authenticate"), "SCRAM-SHA-256")
+		if !ok || params["sid"] == "" {
+			t.Fatal("Session should be created:", w.Header())
+		}
+		sids = append(sids, params["sid"])
+	}
+
+	if len(h.sessions) != 3 || len(h.queue) != 3 {
+		t.Fatalf("Number of sessions should be limited, got %d sessions and %d queued", len(h.sessions), len(h.queue))
+	}
+	for i, sid := range sids {
+		if _, ok := h.sessions[sid]; ok != (i >= 2) {
+			t.Errorf("Only the oldest sessions should be dropped, session %d kept: %v", i, ok)
+		}
+	}
+}
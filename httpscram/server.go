@@ -0,0 +1,174 @@
+// Package implements SCRAM HTTP authentication scheme described in RFC 7804
+package httpscram
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/scram"
+)
+
+const SID_BYTES = 16
+
+// Time client has to send Client Final message after Server First message
+const SESSION_TIMEOUT = time.Minute
+
+// Maximal number of sessions waiting for Client Final message.
+// When the limit is reached the oldest session is dropped
+const MAX_SESSIONS = 10000
+
+type contextKey struct{}
+
+// Returns name of the user authenticated by Handler
+func UserName(r *http.Request) string {
+	name, _ := r.Context().Value(contextKey{}).(string)
+	return name
+}
+
+type session struct {
+	server  *scram.Server
+	expires time.Time
+}
+
+// Id of created session. Sessions have the same timeout, so they expire in order of creation
+type pending struct {
+	sid     string
+	expires time.Time
+}
+
+// Middleware authenticating requests with SCRAM before passing them to next handler
+type Handler struct {
+	next    http.Handler
+	realm   string
+	variant *scram.Variant
+	store   scram.CredentialStore
+	gen     sasl.SaltGenerator
+
+	mu           sync.Mutex
+	sessions     map[string]*session
+	queue        []pending // Sessions in order of creation, may contain already finished ones
+	max_sessions int
+}
+
+// Creates SCRAM-SHA-256 middleware authenticating users with stored credentials
+func NewHandler(next http.Handler, realm string, store scram.CredentialStore) *Handler {
+	return &Handler{
+		next:         next,
+		realm:        realm,
+		variant:      scram.SHA_256,
+		store:        store,
+		gen:          scram.DefaultGenerator,
+		sessions:     make(map[string]*session),
+		max_sessions: MAX_SESSIONS,
+	}
+}
+
+// Sets SCRAM variant used instead of SCRAM-SHA-256
+func (h *Handler) SetVariant(v *scram.Variant) {
+	h.variant = v
+}
+
+// Sets generator used for nonces and session ids
+func (h *Handler) SetGenerator(gen sasl.SaltGenerator) {
+	h.gen = gen
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseHeader(r.Header.Get("Authorization"), h.variant.Name)
+	if !ok {
+		h.challenge(w)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(params["data"])
+	if err != nil || len(data) == 0 {
+		h.challenge(w)
+		return
+	}
+
+	if sid, ok := params["sid"]; ok {
+		h.final(w, r, sid, data)
+	} else {
+		h.first(w, data)
+	}
+}
+
+// Handles Client First message creating new session
+func (h *Handler) first(w http.ResponseWriter, data []byte) {
+	s := h.variant.NewServer(h.gen)
+	s.SetCredentialStore(h.store)
+
+	sfirst, _, err := s.Step(data)
+	if err != nil {
+		h.challenge(w)
+		return
+	}
+
+	sid, err := h.gen.GetSalt(SID_BYTES)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	id, expires := hex.EncodeToString(sid), time.Now().Add(SESSION_TIMEOUT)
+	h.mu.Lock()
+	h.expire()
+	for len(h.queue) >= h.max_sessions {
+		h.drop()
+	}
+	h.sessions[id] = &session{server: s, expires: expires}
+	h.queue = append(h.queue, pending{sid: id, expires: expires})
+	h.mu.Unlock()
+
+	w.Header().Set("WWW-Authenticate", formatHeader(h.variant.Name,
+		"sid", id,
+		"data", base64.StdEncoding.EncodeToString(sfirst)))
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// Handles Client Final message and serves request if client is authenticated
+func (h *Handler) final(w http.ResponseWriter, r *http.Request, sid string, data []byte) {
+	h.mu.Lock()
+	h.expire()
+	sess, ok := h.sessions[sid]
+	delete(h.sessions, sid)
+	h.mu.Unlock()
+
+	if !ok {
+		h.challenge(w)
+		return
+	}
+
+	sfinal, done, err := sess.server.Step(data)
+	if err != nil || !done {
+		h.challenge(w)
+		return
+	}
+
+	w.Header().Set("Authentication-Info", formatParams("sid", sid, "data", base64.StdEncoding.EncodeToString(sfinal)))
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, sess.server.UserName())))
+}
+
+func (h *Handler) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", formatHeader(h.variant.Name, "realm", h.realm))
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// Removes expired sessions from the beginning of queue. Should be called with mutex locked
+func (h *Handler) expire() {
+	now := time.Now()
+	for len(h.queue) > 0 && now.After(h.queue[0].expires) {
+		h.drop()
+	}
+}
+
+// Removes the oldest session. Should be called with mutex locked
+func (h *Handler) drop() {
+	delete(h.sessions, h.queue[0].sid)
+	h.queue = h.queue[1:]
+}
@@ -0,0 +1,189 @@
+// Package implements non-standard LOGIN mechanism described in
+// draft-murchison-sasl-login. Password is sent in clear text, so mechanism
+// should be used over TLS only
+package login
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/goxmpp/sasl"
+)
+
+var (
+	USERNAME_PROMPT = []byte("Username:")
+	PASSWORD_PROMPT = []byte("Password:")
+)
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:      "LOGIN",
+		Plaintext: true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			return NewClient(cfg.Username, cfg.Password), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			s := NewServer()
+			s.SetPasswordStore(cfg.Passwords)
+			return s, nil
+		},
+	})
+}
+
+type prompt int
+
+const (
+	promptUnknown prompt = iota
+	promptUsername
+	promptPassword
+)
+
+// Recognizes prompt variants sent by servers, e.g. "Username:", "User Name\x00" or "password:"
+func parsePrompt(challenge []byte) prompt {
+	p := bytes.ToLower(bytes.TrimRight(challenge, "\x00: \r\n"))
+	p = bytes.ReplaceAll(p, []byte(" "), nil)
+
+	switch string(p) {
+	case "username", "user", "login":
+		return promptUsername
+	case "password", "pass":
+		return promptPassword
+	}
+	return promptUnknown
+}
+
+type Client struct {
+	username, password string
+	sent_username      bool
+	sent_password      bool
+	done               bool
+}
+
+func NewClient(username, password string) *Client {
+	return &Client{username: username, password: password}
+}
+
+// Implements sasl.ClientMechanism. LOGIN has no initial response, so empty challenge
+// of the first step is answered with empty response. Prompts not recognized are
+// answered with user name first and password next
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	if c.done {
+		return nil, false, WrongServerMessage("Authentication exchange is already completed")
+	}
+
+	if len(challenge) == 0 {
+		if c.sent_password {
+			// Success after password was sent
+			c.done = true
+			return nil, true, nil
+		}
+		if !c.sent_username {
+			return nil, false, nil
+		}
+	}
+
+	p := parsePrompt(challenge)
+	if p == promptUnknown {
+		p = promptUsername
+		if c.sent_username {
+			p = promptPassword
+		}
+	}
+
+	switch {
+	case p == promptUsername && !c.sent_username:
+		c.sent_username = true
+		return []byte(c.username), false, nil
+	case p == promptPassword && c.sent_username && !c.sent_password:
+		c.sent_password = true
+		return []byte(c.password), false, nil
+	}
+	return nil, false, WrongServerMessage("Unexpected prompt")
+}
+
+type Server struct {
+	store    sasl.PasswordStore
+	username string
+	password []byte
+	step     int
+	failed   bool
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Sets passwords store used to authenticate users with Step
+func (s *Server) SetPasswordStore(store sasl.PasswordStore) {
+	s.store = store
+}
+
+// LOGIN doesn't support authorization identity, so UserName is returned
+func (s *Server) AuthID() string {
+	return s.username
+}
+
+// Returns UserName provided by client
+func (s *Server) UserName() string {
+	return s.username
+}
+
+// Checks password provided by client
+func (s *Server) Validate(password string) error {
+	if !sasl.SecureEqual([]byte(password), s.password) {
+		return WrongClientMessage("Wrong password provided")
+	}
+	return nil
+}
+
+// Implements sasl.ServerMechanism. User name sent as initial response is accepted.
+// SetPasswordStore should be called before this method usage.
+// Exchange ends on the first error, so password can't be guessed again in the same session
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	if s.failed {
+		return nil, false, WrongClientMessage("Authentication has already failed")
+	}
+
+	chal, done, err := s.exchange(response)
+	if err != nil {
+		s.failed = true
+	}
+	return chal, done, err
+}
+
+// Handles response according to current step, Step marks session failed on errors
+func (s *Server) exchange(response []byte) ([]byte, bool, error) {
+	switch s.step {
+	case 0:
+		s.step++
+		if len(response) == 0 {
+			return sasl.MakeCopy(USERNAME_PROMPT), false, nil
+		}
+		fallthrough
+	case 1:
+		if len(response) == 0 {
+			return nil, false, WrongClientMessage("Empty user name")
+		}
+		s.username = string(response)
+		s.step = 2
+		return sasl.MakeCopy(PASSWORD_PROMPT), false, nil
+	case 2:
+		s.password = sasl.MakeCopy(response)
+
+		if s.store == nil {
+			return nil, false, errors.New("Password store is not set")
+		}
+		password, err := s.store.Password(s.username)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := s.Validate(password); err != nil {
+			return nil, false, err
+		}
+
+		s.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongClientMessage("Authentication exchange is already completed")
+}
@@ -0,0 +1,92 @@
+package login
+
+import "testing"
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	return p[username], nil
+}
+
+func TestPromptVariants(t *testing.T) {
+	for _, prompts := range [][2]string{
+		{"Username:", "Password:"},
+		{"User Name\x00", "Password\x00"},
+		{"username:", "password:"},
+		{"Login:", "Pass:"},
+		{"Who are you?", "Secret?"},
+	} {
+		c := NewClient("tim", "tanstaaf")
+
+		if resp, _, err := c.Step(nil); err != nil || len(resp) != 0 {
+			t.Fatal("Client should not send initial response", err)
+		}
+		if resp, _, err := c.Step([]byte(prompts[0])); err != nil || string(resp) != "tim" {
+			t.Fatalf("User name should be sent for %q: %q %v", prompts[0], resp, err)
+		}
+		if resp, _, err := c.Step([]byte(prompts[1])); err != nil || string(resp) != "tanstaaf" {
+			t.Fatalf("Password should be sent for %q: %q %v", prompts[1], resp, err)
+		}
+		if _, done, err := c.Step(nil); err != nil || !done {
+			t.Fatal("Client should complete authentication", err)
+		}
+	}
+
+	c := NewClient("tim", "tanstaaf")
+	if _, _, err := c.Step([]byte("Password:")); err == nil {
+		t.Fatal("Password should not be sent before user name")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	s := NewServer()
+	s.SetPasswordStore(passwords{"tim": "tanstaaf"})
+
+	chal, _, err := s.Step(nil)
+	if err != nil || string(chal) != "Username:" {
+		t.Fatal("Server should ask for user name", err)
+	}
+	if chal, _, err = s.Step([]byte("tim")); err != nil || string(chal) != "Password:" {
+		t.Fatal("Server should ask for password", err)
+	}
+	if _, done, err := s.Step([]byte("tanstaaf")); err != nil || !done {
+		t.Fatal("Authentication failed", err)
+	}
+	if s.UserName() != "tim" || s.AuthID() != "tim" {
+		t.Fatal("Wrong identity authenticated:", s.UserName())
+	}
+}
+
+func TestInitialResponse(t *testing.T) {
+	s := NewServer()
+	s.SetPasswordStore(passwords{"tim": "tanstaaf"})
+
+	if chal, _, err := s.Step([]byte("tim")); err != nil || string(chal) != "Password:" {
+		t.Fatal("User name should be accepted as initial response", err)
+	}
+	if _, _, err := s.Step([]byte("wrong")); err == nil {
+		t.Fatal("Wrong password should be rejected")
+	}
+}
+
+func TestStepFailure(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.Step([]byte("tim")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Step([]byte("tanstaaf")); err == nil {
+		t.Fatal("Server without store should fail")
+	}
+
+	s = NewServer()
+	s.SetPasswordStore(passwords{"tim": "tanstaaf"})
+	if _, _, err := s.Step([]byte("tim")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Step([]byte("wrong")); err == nil {
+		t.Fatal("Wrong password should be rejected")
+	}
+	if _, _, err := s.Step([]byte("tanstaaf")); err == nil {
+		t.Fatal("Failed exchange should not be continued")
+	}
+}
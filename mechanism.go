@@ -0,0 +1,184 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Client side of authentication mechanism.
+// Step should be called with every challenge received from server (empty
+// challenge for the initial response) and returns response which should be
+// sent back to server. done is true when authentication exchange is completed
+type ClientMechanism interface {
+	Step(challenge []byte) (response []byte, done bool, err error)
+}
+
+// Server side of authentication mechanism.
+// Step should be called with every response received from client (empty
+// response if client didn't provide initial response) and returns challenge
+// which should be sent back to client. When done is true returned data
+// should be sent as additional data of success message. When err is not nil
+// returned data, if any, describes error in mechanism specific way
+type ServerMechanism interface {
+	Step(response []byte) (challenge []byte, done bool, err error)
+	// Returns authorization identity requested by client or UserName if none was provided
+	AuthID() string
+	// Returns authentication identity provided by client
+	UserName() string
+}
+
+// Used by server mechanisms to get password of the user
+type PasswordStore interface {
+	Password(username string) (string, error)
+}
+
+// Set of parameters used to construct mechanisms from registry.
+// Client side mechanisms use Username, Password and AuthID,
+// server side mechanisms use Passwords to authenticate users
+type Config struct {
+	Generator SaltGenerator // optional, nil means default generator of mechanism's package
+
+	Username string
+	Password string
+	AuthID   string
+
+	Passwords PasswordStore
+
+	// Channel bindings of underlying TLS connection. Client side uses the first one,
+	// server side accepts any of them. Required by -PLUS mechanisms
+	ChannelBindings []*ChannelBinding
+
+	// Mechanisms and channel binding types advertised by server. Used by
+	// mechanisms supporting downgrade protection, e.g. SCRAM with XEP-0474
+	Advertised         []string
+	AdvertisedBindings []string
+
+	// State of underlying TLS connection. Used by mechanisms relying on
+	// client certificates, e.g. EXTERNAL
+	TLS *tls.ConnectionState
+
+	// Issued tokens and counter used by token based mechanisms, e.g. HT with XEP-0484 FAST.
+	// Tokens should be ht.TokenStore or *ht.Issuer, Counter is count attribute sent by client
+	Tokens  interface{}
+	Counter uint64
+
+	Service string // Service name, e.g. "xmpp"
+	Host    string // Server host name
+	Realm   string // Realm used by mechanisms supporting it. Host will be used if empty
+}
+
+type ClientFactory func(cfg *Config) (ClientMechanism, error)
+type ServerFactory func(cfg *Config) (ServerMechanism, error)
+
+// Describes authentication mechanism registered under IANA name.
+// Security properties are used by Negotiate to pick the best mechanism
+type Mechanism struct {
+	Name      string
+	NewClient ClientFactory
+	NewServer ServerFactory
+
+	HashBits       int  // Output size of hash function protecting password, 0 if none
+	ChannelBinding bool // true if authentication is bound to TLS channel
+	Plaintext      bool // true if password or token is sent in clear text
+	Explicit       bool // true if mechanism is never negotiated unless allowed by name
+}
+
+type UnknownMechanism string
+
+func (um UnknownMechanism) Error() string {
+	return fmt.Sprintf("Unknown mechanism: %s", string(um))
+}
+
+// Implemented by mechanism errors caused by client's message which can't be parsed,
+// so protocol drivers can report them apart from failed authentication
+type MalformedRequest interface {
+	MalformedRequest() bool
+}
+
+// Implemented by mechanism errors caused by client's message which isn't encoded properly
+type IncorrectEncoding interface {
+	IncorrectEncoding() bool
+}
+
+// Implemented by mechanism errors caused by authorization identity client isn't allowed to use
+type InvalidAuthzID interface {
+	InvalidAuthzID() bool
+}
+
+// Implemented by mechanism errors caused by temporary condition of server,
+// so client can retry authentication later
+type TemporaryFailure interface {
+	TemporaryFailure() bool
+}
+
+var (
+	mechanismsMu sync.RWMutex
+	mechanisms   = make(map[string]*Mechanism)
+)
+
+// Makes mechanism available by its name. Packages implementing
+// mechanisms call it from init function.
+// Panics if mechanism with same name was already registered
+func Register(m *Mechanism) {
+	mechanismsMu.Lock()
+	defer mechanismsMu.Unlock()
+
+	if m == nil || m.Name == "" {
+		panic("sasl: Register mechanism without name")
+	}
+	if _, ok := mechanisms[m.Name]; ok {
+		panic("sasl: Register called twice for mechanism " + m.Name)
+	}
+	mechanisms[m.Name] = m
+}
+
+// Returns mechanism registered under provided name
+func Lookup(name string) (*Mechanism, error) {
+	mechanismsMu.RLock()
+	defer mechanismsMu.RUnlock()
+
+	m, ok := mechanisms[name]
+	if !ok {
+		return nil, UnknownMechanism(name)
+	}
+	return m, nil
+}
+
+// Returns sorted list of registered mechanism names
+func Mechanisms() []string {
+	mechanismsMu.RLock()
+	defer mechanismsMu.RUnlock()
+
+	names := make([]string, 0, len(mechanisms))
+	for name := range mechanisms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates client side of mechanism registered under provided name
+func NewClient(name string, cfg *Config) (ClientMechanism, error) {
+	m, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.NewClient == nil {
+		return nil, UnknownMechanism(name)
+	}
+	return m.NewClient(cfg)
+}
+
+// Creates server side of mechanism registered under provided name
+func NewServer(name string, cfg *Config) (ServerMechanism, error) {
+	m, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.NewServer == nil {
+		return nil, UnknownMechanism(name)
+	}
+	return m.NewServer(cfg)
+}
@@ -0,0 +1,108 @@
+package sasl_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+	_ "github.com/goxmpp/sasl/crammd5"
+	_ "github.com/goxmpp/sasl/digest"
+	_ "github.com/goxmpp/sasl/login"
+	_ "github.com/goxmpp/sasl/plain"
+	_ "github.com/goxmpp/sasl/scram"
+)
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	if password, ok := p[username]; ok {
+		return password, nil
+	}
+	return "", errors.New("Unknown user")
+}
+
+// Runs authentication exchange between client and server
+// the way protocol driver would do it
+func exchange(c sasl.ClientMechanism, s sasl.ServerMechanism) error {
+	resp, _, err := c.Step(nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		chal, sdone, err := s.Step(resp)
+		if err != nil {
+			return err
+		}
+
+		var cdone bool
+		if resp, cdone, err = c.Step(chal); err != nil {
+			return err
+		}
+
+		if sdone {
+			if !cdone {
+				return errors.New("Server completed exchange before client")
+			}
+			return nil
+		}
+	}
+}
+
+func TestRegisteredMechanisms(t *testing.T) {
+	for _, name := range []string{"SCRAM-SHA-1", "SCRAM-SHA-256", "DIGEST-MD5"} {
+		if _, err := sasl.Lookup(name); err != nil {
+			t.Fatal(name, "should be registered:", err)
+		}
+	}
+
+	if _, err := sasl.NewClient("UNKNOWN", &sasl.Config{}); err == nil {
+		t.Fatal("Unknown mechanism should not be created")
+	}
+}
+
+// Returns channel bindings which should be used with mechanism
+func bindingsFor(name string) []*sasl.ChannelBinding {
+	if strings.HasSuffix(name, "-PLUS") {
+		return []*sasl.ChannelBinding{{Type: sasl.TLS_EXPORTER, Data: []byte("exported keying material")}}
+	}
+	return nil
+}
+
+func TestMechanismsExchange(t *testing.T) {
+	store := passwords{"user": "pencil"}
+
+	for _, name := range sasl.Mechanisms() {
+		c, err := sasl.NewClient(name, &sasl.Config{Username: "user", Password: "pencil", Service: "xmpp", Host: "example.com", ChannelBindings: bindingsFor(name)})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		s, err := sasl.NewServer(name, &sasl.Config{Passwords: store, Host: "example.com", ChannelBindings: bindingsFor(name)})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		if err := exchange(c, s); err != nil {
+			t.Fatal(name, "authentication failed:", err)
+		}
+
+		if s.UserName() != "user" || s.AuthID() != "user" {
+			t.Fatal(name, "wrong identity authenticated:", s.UserName(), s.AuthID())
+		}
+	}
+}
+
+func TestMechanismsWrongPassword(t *testing.T) {
+	store := passwords{"user": "pencil"}
+
+	for _, name := range sasl.Mechanisms() {
+		c, _ := sasl.NewClient(name, &sasl.Config{Username: "user", Password: "pen", Service: "xmpp", Host: "example.com", ChannelBindings: bindingsFor(name)})
+		s, _ := sasl.NewServer(name, &sasl.Config{Passwords: store, Host: "example.com", ChannelBindings: bindingsFor(name)})
+
+		if err := exchange(c, s); err == nil {
+			t.Fatal(name, "authentication with wrong password should fail")
+		}
+	}
+}
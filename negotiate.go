@@ -0,0 +1,134 @@
+package sasl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Client side restrictions applied to mechanisms advertised by server
+type Policy struct {
+	// Names of mechanisms client may use in order of preference. If empty all
+	// registered mechanisms except Explicit ones are allowed, stronger ones first
+	Allowed []string
+
+	RequireChannelBinding bool // Only -PLUS mechanisms are acceptable
+	// Mechanisms sending password in clear text are rejected unless Config.TLS is set
+	ForbidPlaintextWithoutTLS bool
+	// Minimum HashBits of acceptable mechanism. Mechanisms not using hash functions,
+	// e.g. PLAIN, are accepted only if Config.TLS is set
+	MinHashBits int
+}
+
+// Returned by Negotiate when none of advertised mechanisms is acceptable
+type NegotiationError struct {
+	Advertised []string
+	Rejected   map[string]string // Reasons mechanisms were rejected by their names
+}
+
+func (ne *NegotiationError) Error() string {
+	reasons := make([]string, 0, len(ne.Advertised))
+	for _, name := range ne.Advertised {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, ne.Rejected[name]))
+	}
+	return fmt.Sprintf("No acceptable mechanism among advertised [%s]", strings.Join(reasons, "; "))
+}
+
+// Picks the best of mechanisms advertised by server acceptable by policy
+// and creates its client side using provided config. Bindings are channel binding
+// types advertised by server, e.g. with XEP-0440, nil if server didn't advertise them.
+// Both lists are used for downgrade protection unless cfg.Advertised is already set.
+// nil policy means default Policy, nil cfg means empty Config
+func Negotiate(advertised, bindings []string, policy *Policy, cfg *Config) (ClientMechanism, string, error) {
+	if policy == nil {
+		policy = &Policy{}
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	nerr := &NegotiationError{Advertised: advertised, Rejected: make(map[string]string)}
+
+	var candidates []*Mechanism
+	for _, name := range advertised {
+		m, err := Lookup(name)
+		if err != nil {
+			nerr.Rejected[name] = "not supported"
+			continue
+		}
+		if reason := policy.reject(m, cfg); reason != "" {
+			nerr.Rejected[name] = reason
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+
+	policy.sort(candidates)
+
+	if len(cfg.Advertised) == 0 {
+		// Let mechanisms protect themselves against downgrade
+		copied := *cfg
+		copied.Advertised = advertised
+		copied.AdvertisedBindings = bindings
+		cfg = &copied
+	}
+
+	for _, m := range candidates {
+		c, err := NewClient(m.Name, cfg)
+		if err != nil {
+			nerr.Rejected[m.Name] = err.Error()
+			continue
+		}
+		return c, m.Name, nil
+	}
+
+	return nil, "", nerr
+}
+
+// Returns reason mechanism is not acceptable or empty string
+func (p *Policy) reject(m *Mechanism, cfg *Config) string {
+	switch {
+	case len(p.Allowed) > 0 && p.preference(m.Name) < 0:
+		return "not allowed"
+	case len(p.Allowed) == 0 && m.Explicit:
+		return "should be allowed explicitly"
+	case p.RequireChannelBinding && !m.ChannelBinding:
+		return "channel binding is required"
+	case m.ChannelBinding && len(cfg.ChannelBindings) == 0:
+		return "no channel binding available"
+	case m.Plaintext && p.ForbidPlaintextWithoutTLS && cfg.TLS == nil:
+		return "plaintext is forbidden without TLS"
+	case m.HashBits == 0 && p.MinHashBits > 0 && cfg.TLS == nil:
+		return "password is not hashed and TLS is not used"
+	case m.HashBits > 0 && m.HashBits < p.MinHashBits:
+		return "hash function is too weak"
+	}
+	return ""
+}
+
+func (p *Policy) preference(name string) int {
+	for i, allowed := range p.Allowed {
+		if allowed == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Orders mechanisms by preference if Allowed is set, otherwise by strength
+func (p *Policy) sort(ms []*Mechanism) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		if len(p.Allowed) > 0 {
+			return p.preference(ms[i].Name) < p.preference(ms[j].Name)
+		}
+
+		left, right := ms[i], ms[j]
+		switch {
+		case left.Plaintext != right.Plaintext:
+			return !left.Plaintext
+		case left.ChannelBinding != right.ChannelBinding:
+			return left.ChannelBinding
+		}
+		return left.HashBits > right.HashBits
+	})
+}
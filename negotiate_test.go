@@ -0,0 +1,97 @@
+package sasl_test
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+)
+
+func TestNegotiate(t *testing.T) {
+	advertised := []string{"PLAIN", "X-UNKNOWN", "DIGEST-MD5", "CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256", "SCRAM-SHA-256-PLUS"}
+	bindings := []*sasl.ChannelBinding{{Type: sasl.TLS_EXPORTER, Data: []byte("exported keying material")}}
+	state := &tls.ConnectionState{HandshakeComplete: true}
+
+	for _, tc := range []struct {
+		advertised []string
+		policy     *sasl.Policy
+		cfg        *sasl.Config
+		expected   string
+	}{
+		{advertised, nil, &sasl.Config{}, "SCRAM-SHA-256"},
+		{advertised, nil, &sasl.Config{ChannelBindings: bindings}, "SCRAM-SHA-256-PLUS"},
+		{advertised, &sasl.Policy{RequireChannelBinding: true}, &sasl.Config{ChannelBindings: bindings}, "SCRAM-SHA-256-PLUS"},
+		{advertised, &sasl.Policy{Allowed: []string{"DIGEST-MD5", "SCRAM-SHA-1"}}, &sasl.Config{}, "DIGEST-MD5"},
+		{[]string{"PLAIN", "DIGEST-MD5"}, nil, &sasl.Config{}, "DIGEST-MD5"},
+		{[]string{"PLAIN", "DIGEST-MD5"}, &sasl.Policy{MinHashBits: 256}, &sasl.Config{TLS: state}, "PLAIN"},
+		{[]string{"PLAIN", "DIGEST-MD5"}, &sasl.Policy{MinHashBits: 256, ForbidPlaintextWithoutTLS: true}, &sasl.Config{TLS: state}, "PLAIN"},
+	} {
+		c, name, err := sasl.Negotiate(tc.advertised, nil, tc.policy, tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tc.expected || c == nil {
+			t.Fatalf("Expected %s to be negotiated, got %s", tc.expected, name)
+		}
+	}
+}
+
+func TestNegotiateFailure(t *testing.T) {
+	for _, tc := range []struct {
+		advertised []string
+		policy     *sasl.Policy
+		reason     string
+	}{
+		{[]string{"SCRAM-SHA-1", "SCRAM-SHA-256"}, &sasl.Policy{RequireChannelBinding: true}, "channel binding is required"},
+		{[]string{"SCRAM-SHA-256-PLUS"}, nil, "no channel binding available"},
+		{[]string{"PLAIN"}, &sasl.Policy{ForbidPlaintextWithoutTLS: true}, "plaintext is forbidden without TLS"},
+		{[]string{"DIGEST-MD5", "CRAM-MD5"}, &sasl.Policy{MinHashBits: 160}, "hash function is too weak"},
+		{[]string{"PLAIN", "LOGIN"}, &sasl.Policy{MinHashBits: 256}, "password is not hashed and TLS is not used"},
+		{[]string{"SCRAM-SHA-1"}, &sasl.Policy{Allowed: []string{"SCRAM-SHA-256"}}, "not allowed"},
+		{[]string{"X-UNKNOWN"}, nil, "not supported"},
+		{nil, nil, ""},
+	} {
+		_, _, err := sasl.Negotiate(tc.advertised, nil, tc.policy, &sasl.Config{})
+		nerr, ok := err.(*sasl.NegotiationError)
+		if !ok {
+			t.Fatalf("NegotiationError expected for %v, got %v", tc.advertised, err)
+		}
+
+		for _, name := range tc.advertised {
+			if nerr.Rejected[name] != tc.reason {
+				t.Fatalf("Expected %s to be rejected with %q, got %q", name, tc.reason, nerr.Rejected[name])
+			}
+		}
+		if tc.reason != "" && !strings.Contains(err.Error(), tc.reason) {
+			t.Fatal("Error should describe reason:", err)
+		}
+	}
+}
+
+func TestNegotiateNilConfig(t *testing.T) {
+	if _, name, err := sasl.Negotiate([]string{"PLAIN", "SCRAM-SHA-1"}, nil, nil, nil); err != nil || name != "SCRAM-SHA-1" {
+		t.Fatal("nil config should be treated as empty:", name, err)
+	}
+}
+
+// Server advertising channel binding types protects them with SCRAM downgrade protection
+func TestNegotiateDowngradeProtection(t *testing.T) {
+	advertised, bindings := []string{"PLAIN", "SCRAM-SHA-256"}, []string{sasl.TLS_EXPORTER}
+	s, err := sasl.NewServer("SCRAM-SHA-256", &sasl.Config{
+		Passwords:          passwords{"user": "pencil"},
+		Advertised:         advertised,
+		AdvertisedBindings: bindings,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _, err := sasl.Negotiate(advertised, bindings, nil, &sasl.Config{Username: "user", Password: "pencil"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(c, s); err != nil {
+		t.Fatal("Negotiated mechanism should pass downgrade protection:", err)
+	}
+}
@@ -0,0 +1,53 @@
+package oauthbearer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type WrongClientMessage string
+
+func (wcm WrongClientMessage) Error() string {
+	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
+}
+
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
+
+type WrongServerMessage string
+
+func (wsm WrongServerMessage) Error() string {
+	return fmt.Sprintf("Wrong Server Message Provided: %s", string(wsm))
+}
+
+// Returned when server mechanism is created without TokenVerifier
+type VerifierRequired string
+
+func (vr VerifierRequired) Error() string {
+	return fmt.Sprintf("Token verifier is required by %s", string(vr))
+}
+
+// JSON error challenge sent by server when token is rejected, see RFC 7628 section 3.2.2.
+// Verifiers may return it to control challenge content
+type ErrorChallenge struct {
+	Status              string `json:"status"`
+	Scope               string `json:"scope,omitempty"`
+	Schemes             string `json:"schemes,omitempty"`
+	OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+}
+
+func (ec *ErrorChallenge) Error() string {
+	return fmt.Sprintf("Token rejected by server: %s", ec.Status)
+}
+
+func parseErrorChallenge(challenge []byte) (*ErrorChallenge, error) {
+	ec := &ErrorChallenge{}
+	if err := json.Unmarshal(challenge, ec); err != nil {
+		return nil, WrongServerMessage("Error challenge is not valid JSON")
+	}
+	if ec.Status == "" {
+		return nil, WrongServerMessage("Error challenge without status")
+	}
+	return ec, nil
+}
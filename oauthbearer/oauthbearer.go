@@ -0,0 +1,311 @@
+// Package implements OAUTHBEARER mechanism described in RFC 7628
+// and XOAUTH2 mechanism used by Google and Microsoft services
+package oauthbearer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/scram"
+)
+
+// Separator of key/value pairs
+const KVSEP = 0x01
+
+const BEARER = "Bearer "
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:      "OAUTHBEARER",
+		Plaintext: true,
+		Explicit:  true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			c := NewClient(cfg.AuthID, cfg.Password)
+			c.SetHost(cfg.Host, 0)
+			return c, nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			verifier, ok := cfg.Passwords.(TokenVerifier)
+			if !ok {
+				return nil, VerifierRequired("OAUTHBEARER")
+			}
+			return NewServer(verifier), nil
+		},
+	})
+	sasl.Register(&sasl.Mechanism{
+		Name:      "XOAUTH2",
+		Plaintext: true,
+		Explicit:  true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			return NewXOAuth2Client(cfg.Username, cfg.Password), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			verifier, ok := cfg.Passwords.(TokenVerifier)
+			if !ok {
+				return nil, VerifierRequired("XOAUTH2")
+			}
+			return NewXOAuth2Server(verifier), nil
+		},
+	})
+}
+
+// Token and parameters sent by client
+type Request struct {
+	AuthID string // Authorization identity, user name for XOAUTH2
+	Host   string
+	Port   int
+	Token  string
+	Params map[string]string // Other key/value pairs
+}
+
+// Used by server mechanisms to validate bearer tokens.
+// Returns authenticated user name. *ErrorChallenge may be returned
+// to control content of error challenge sent to client
+type TokenVerifier interface {
+	VerifyToken(req *Request) (username string, err error)
+}
+
+type Client struct {
+	auth_id string
+	token   string
+	host    string
+	port    int
+	failure *ErrorChallenge
+	step    int
+}
+
+// Creates client sending bearer token and optionally requesting auth_id authorization identity
+func NewClient(auth_id, token string) *Client {
+	return &Client{auth_id: auth_id, token: token}
+}
+
+// Sets host and port client connects to. Zero port isn't sent
+func (c *Client) SetHost(host string, port int) {
+	c.host, c.port = host, port
+}
+
+// Returns error challenge received from server if token was rejected
+func (c *Client) Failure() *ErrorChallenge {
+	return c.failure
+}
+
+// Generates client's message with GS2 header and bearer token
+func (c *Client) Response() []byte {
+	header := &scram.GS2Header{Flag: 'n', AuthID: []byte(c.auth_id)}
+
+	msg := append(header.Bytes(), KVSEP)
+	if c.host != "" {
+		msg = append(append(append(msg, "host="...), c.host...), KVSEP)
+	}
+	if c.port != 0 {
+		msg = append(strconv.AppendInt(append(msg, "port="...), int64(c.port), 10), KVSEP)
+	}
+	msg = append(append(append(msg, "auth="+BEARER...), c.token...), KVSEP)
+	return append(msg, KVSEP)
+}
+
+// Implements sasl.ClientMechanism. When server rejects token, dummy response
+// required by RFC 7628 is returned and error challenge is available with Failure
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		c.step++
+		return c.Response(), false, nil
+	case 1:
+		c.step++
+		if len(challenge) == 0 {
+			return nil, true, nil
+		}
+
+		failure, err := parseErrorChallenge(challenge)
+		if err != nil {
+			return nil, false, err
+		}
+		c.failure = failure
+		return []byte{KVSEP}, false, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+const (
+	step_initial    = iota
+	step_challenged // Error challenge was sent, client's dummy response is expected
+	step_done
+	step_failed
+)
+
+// Server side exchange shared by OAUTHBEARER and XOAUTH2
+type server struct {
+	verifier TokenVerifier
+	request  *Request
+	username string
+	failure  error
+	step     int
+}
+
+// Returns user name returned by verifier
+func (s *server) UserName() string {
+	return s.username
+}
+
+// Returns request parsed from client's message
+func (s *server) Request() *Request {
+	return s.request
+}
+
+// Parses client's message with parse and verifies token. Rejected token is reported
+// with challenge made by challenge and verifier's error is returned after client's
+// reply accepted by dummy. Exchange can't be continued after any error
+func (s *server) exchange(response []byte, parse func([]byte) error, challenge func(error) ([]byte, error), dummy func([]byte) error) ([]byte, bool, error) {
+	switch s.step {
+	case step_initial:
+		if len(response) == 0 {
+			// Client didn't send initial response, ask for it with empty challenge
+			return []byte{}, false, nil
+		}
+
+		if err := parse(response); err != nil {
+			return s.fail(err)
+		}
+
+		username, err := s.verifier.VerifyToken(s.request)
+		if err != nil {
+			chal, cerr := challenge(err)
+			if cerr != nil {
+				return s.fail(cerr)
+			}
+			s.failure, s.step = err, step_challenged
+			return chal, false, nil
+		}
+
+		s.username, s.step = username, step_done
+		return nil, true, nil
+	case step_challenged:
+		if err := dummy(response); err != nil {
+			return s.fail(err)
+		}
+		return s.fail(s.failure)
+	case step_failed:
+		return nil, false, s.failure
+	}
+
+	return nil, false, WrongClientMessage("Authentication exchange is already completed")
+}
+
+func (s *server) fail(err error) ([]byte, bool, error) {
+	s.failure, s.step = err, step_failed
+	return nil, false, err
+}
+
+type Server struct {
+	server
+}
+
+func NewServer(verifier TokenVerifier) *Server {
+	return &Server{server{verifier: verifier}}
+}
+
+// Returns requested authorization identity or UserName if none was requested
+func (s *Server) AuthID() string {
+	if s.request != nil && s.request.AuthID != "" {
+		return s.request.AuthID
+	}
+	return s.username
+}
+
+// Parses client's message with GS2 header and key/value pairs
+func (s *Server) ParseResponse(response []byte) error {
+	header, rest, err := scram.ParseGS2Header(response)
+	if err != nil {
+		return err
+	}
+	if header.Flag == 'p' {
+		return WrongClientMessage("Channel binding is not supported")
+	}
+
+	if len(rest) == 0 || rest[0] != KVSEP {
+		return WrongClientMessage("Key/value pairs should follow GS2 header")
+	}
+
+	req, err := parsePairs(rest[1:])
+	if err != nil {
+		return err
+	}
+	req.AuthID = string(header.AuthID)
+
+	if req.Port, err = parsePort(req.Params); err != nil {
+		return err
+	}
+	req.Host = req.Params["host"]
+	delete(req.Params, "host")
+
+	s.request = req
+	return nil
+}
+
+// Implements sasl.ServerMechanism. If token is rejected, error challenge
+// is sent and error is returned after client's dummy response
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	return s.exchange(response, s.ParseResponse, errorChallenge, func(response []byte) error {
+		if !bytes.Equal(response, []byte{KVSEP}) {
+			return WrongClientMessage("Expected dummy response to error challenge")
+		}
+		return nil
+	})
+}
+
+// Parses key/value pairs terminated by additional separator and extracts bearer token
+func parsePairs(pairs []byte) (*Request, error) {
+	if !bytes.HasSuffix(pairs, []byte{KVSEP, KVSEP}) {
+		return nil, WrongClientMessage("Message should end with two separators")
+	}
+
+	req := &Request{Params: make(map[string]string)}
+	for _, pair := range bytes.Split(pairs[:len(pairs)-2], []byte{KVSEP}) {
+		eq := bytes.IndexByte(pair, '=')
+		if eq <= 0 {
+			return nil, WrongClientMessage("Wrong key/value pair")
+		}
+		req.Params[string(pair[:eq])] = string(pair[eq+1:])
+	}
+
+	auth, ok := req.Params["auth"]
+	if !ok {
+		return nil, WrongClientMessage("auth is required")
+	}
+	delete(req.Params, "auth")
+
+	if len(auth) <= len(BEARER) || !bytes.EqualFold([]byte(auth[:len(BEARER)]), []byte(BEARER)) {
+		return nil, WrongClientMessage("Only Bearer tokens are supported")
+	}
+	req.Token = auth[len(BEARER):]
+	return req, nil
+}
+
+func parsePort(params map[string]string) (int, error) {
+	value, ok := params["port"]
+	if !ok {
+		return 0, nil
+	}
+	delete(params, "port")
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, WrongClientMessage("Wrong port")
+	}
+	return port, nil
+}
+
+func errorChallenge(err error) ([]byte, error) {
+	ec, ok := err.(*ErrorChallenge)
+	if !ok {
+		ec = &ErrorChallenge{Status: "invalid_token"}
+	}
+	return json.Marshal(ec)
+}
@@ -0,0 +1,156 @@
+package oauthbearer
+
+import (
+	"errors"
+	"testing"
+)
+
+// Example from RFC 7628 section 4.1
+const std_response = "n,a=user@example.com,\x01host=server.example.com\x01port=143\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==\x01\x01"
+
+type tokens map[string]string
+
+func (t tokens) VerifyToken(req *Request) (string, error) {
+	if username, ok := t[req.Token]; ok {
+		return username, nil
+	}
+	if req.Token == "expired" {
+		return "", &ErrorChallenge{Status: "invalid_token", Scope: "example_scope", OpenIDConfiguration: "https://example.com/.well-known/openid-configuration"}
+	}
+	return "", errors.New("Unknown token")
+}
+
+func TestStandardExample(t *testing.T) {
+	c := NewClient("user@example.com", "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==")
+	c.SetHost("server.example.com", 143)
+	if resp := c.Response(); string(resp) != std_response {
+		t.Fatalf("Wrong response generated: %q", resp)
+	}
+
+	s := NewServer(tokens{})
+	if err := s.ParseResponse([]byte(std_response)); err != nil {
+		t.Fatal(err)
+	}
+	req := s.Request()
+	if req.AuthID != "user@example.com" || req.Host != "server.example.com" || req.Port != 143 || req.Token != "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg==" {
+		t.Fatalf("Wrong request parsed: %+v", req)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	for _, resp := range []string{
+		"n,,auth=Bearer token\x01\x01",
+		"n,,\x01auth=Bearer token\x01",
+		"n,,\x01auth=Basic token\x01\x01",
+		"n,,\x01host=example.com\x01\x01",
+		"n,,\x01port=http\x01auth=Bearer token\x01\x01",
+		"p=tls-unique,,\x01auth=Bearer token\x01\x01",
+		"x,,\x01auth=Bearer token\x01\x01",
+	} {
+		if err := NewServer(tokens{}).ParseResponse([]byte(resp)); err == nil {
+			t.Fatalf("Malformed response %q should be rejected", resp)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	c, s := NewClient("", "good"), NewServer(tokens{"good": "user@example.com"})
+
+	resp, _, err := c.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, done, err := s.Step(resp); err != nil || !done {
+		t.Fatal("Authentication failed", err)
+	}
+	if _, done, err := c.Step(nil); err != nil || !done {
+		t.Fatal("Client should complete authentication", err)
+	}
+	if s.UserName() != "user@example.com" || s.AuthID() != "user@example.com" {
+		t.Fatal("Wrong identity authenticated:", s.UserName(), s.AuthID())
+	}
+}
+
+func TestErrorChallenge(t *testing.T) {
+	for token, status := range map[string]string{"expired": "invalid_token", "unknown": "invalid_token"} {
+		c, s := NewClient("", token), NewServer(tokens{})
+
+		resp, _, _ := c.Step(nil)
+		chal, done, err := s.Step(resp)
+		if err != nil || done || len(chal) == 0 {
+			t.Fatal("Server should send error challenge", err)
+		}
+
+		if resp, _, err = c.Step(chal); err != nil || string(resp) != "\x01" {
+			t.Fatalf("Client should send dummy response: %q %v", resp, err)
+		}
+		if c.Failure() == nil || c.Failure().Status != status {
+			t.Fatal("Error challenge should be parsed:", c.Failure())
+		}
+
+		if _, _, err := s.Step(resp); err == nil {
+			t.Fatal("Authentication should fail")
+		}
+	}
+
+	c := NewClient("", "expired")
+	c.Step(nil)
+	c.Step([]byte(`{"status":"invalid_token","scope":"example_scope","openid-configuration":"https://example.com/.well-known/openid-configuration"}`))
+	if f := c.Failure(); f.Scope != "example_scope" || f.OpenIDConfiguration != "https://example.com/.well-known/openid-configuration" {
+		t.Fatalf("Wrong error challenge parsed: %+v", f)
+	}
+
+	c = NewClient("", "x")
+	c.Step(nil)
+	if _, _, err := c.Step([]byte("not json")); err == nil {
+		t.Fatal("Malformed error challenge should be rejected")
+	}
+}
+
+func TestXOAuth2(t *testing.T) {
+	c := NewXOAuth2Client("someuser@example.com", "ya29.vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg")
+	if resp := c.Response(); string(resp) != "user=someuser@example.com\x01auth=Bearer ya29.vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg\x01\x01" {
+		t.Fatalf("Wrong response generated: %q", resp)
+	}
+
+	s := NewXOAuth2Server(tokens{"ya29.vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg": "someuser@example.com"})
+	resp, _, _ := c.Step(nil)
+	if _, done, err := s.Step(resp); err != nil || !done {
+		t.Fatal("Authentication failed", err)
+	}
+	if _, done, err := c.Step(nil); err != nil || !done {
+		t.Fatal("Client should complete authentication", err)
+	}
+
+	c, s = NewXOAuth2Client("someuser@example.com", "bad"), NewXOAuth2Server(tokens{})
+	resp, _, _ = c.Step(nil)
+	chal, _, err := s.Step(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, _, err = c.Step(chal); err != nil || len(resp) != 0 {
+		t.Fatal("Client should send empty response to error challenge", err)
+	}
+	if c.Failure() == nil || c.Failure().Status != "401" {
+		t.Fatal("Error challenge should be parsed:", c.Failure())
+	}
+	if _, _, err := s.Step(resp); err == nil {
+		t.Fatal("Authentication should fail")
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	for name, s := range map[string]interface {
+		Step([]byte) ([]byte, bool, error)
+	}{
+		"OAUTHBEARER": NewServer(tokens{"good": "user@example.com"}),
+		"XOAUTH2":     NewXOAuth2Server(tokens{"good": "user@example.com"}),
+	} {
+		if _, _, err := s.Step([]byte("malformed")); err == nil {
+			t.Fatal(name, "malformed response should be rejected")
+		}
+		if _, done, err := s.Step([]byte("\x01")); err == nil || done {
+			t.Fatal(name, "exchange should not be continued after malformed response")
+		}
+	}
+}
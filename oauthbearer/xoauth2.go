@@ -0,0 +1,113 @@
+package oauthbearer
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type XOAuth2Client struct {
+	username string
+	token    string
+	failure  *ErrorChallenge
+	step     int
+}
+
+// Creates XOAUTH2 client sending bearer token for username
+func NewXOAuth2Client(username, token string) *XOAuth2Client {
+	return &XOAuth2Client{username: username, token: token}
+}
+
+// Returns error challenge received from server if token was rejected
+func (c *XOAuth2Client) Failure() *ErrorChallenge {
+	return c.failure
+}
+
+// Generates client's message in user={User}^Aauth=Bearer {Token}^A^A format
+func (c *XOAuth2Client) Response() []byte {
+	msg := append([]byte("user="), c.username...)
+	msg = append(append(msg, KVSEP), "auth="+BEARER...)
+	msg = append(append(msg, c.token...), KVSEP)
+	return append(msg, KVSEP)
+}
+
+// Implements sasl.ClientMechanism. When server rejects token, empty response
+// is returned and error challenge is available with Failure
+func (c *XOAuth2Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		c.step++
+		return c.Response(), false, nil
+	case 1:
+		c.step++
+		if len(challenge) == 0 {
+			return nil, true, nil
+		}
+
+		failure, err := parseErrorChallenge(challenge)
+		if err != nil {
+			return nil, false, err
+		}
+		c.failure = failure
+		return []byte{}, false, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+type XOAuth2Server struct {
+	server
+}
+
+func NewXOAuth2Server(verifier TokenVerifier) *XOAuth2Server {
+	return &XOAuth2Server{server{verifier: verifier}}
+}
+
+// XOAUTH2 doesn't support authorization identity, so UserName is returned
+func (s *XOAuth2Server) AuthID() string {
+	return s.username
+}
+
+// Returns request parsed from client's message. AuthID contains user name sent by client
+func (s *XOAuth2Server) Request() *Request {
+	return s.request
+}
+
+// Parses client's message in user={User}^Aauth=Bearer {Token}^A^A format
+func (s *XOAuth2Server) ParseResponse(response []byte) error {
+	req, err := parsePairs(response)
+	if err != nil {
+		return err
+	}
+
+	user, ok := req.Params["user"]
+	if !ok || user == "" {
+		return WrongClientMessage("user is required")
+	}
+	delete(req.Params, "user")
+	req.AuthID = user
+
+	s.request = req
+	return nil
+}
+
+// Implements sasl.ServerMechanism. If token is rejected, error challenge
+// is sent and error is returned after client's empty response
+func (s *XOAuth2Server) Step(response []byte) ([]byte, bool, error) {
+	return s.exchange(response, s.ParseResponse, xoauth2Challenge, func(response []byte) error {
+		if len(bytes.TrimSpace(response)) != 0 {
+			return WrongClientMessage("Expected empty response to error challenge")
+		}
+		return nil
+	})
+}
+
+func xoauth2Challenge(err error) ([]byte, error) {
+	ec, ok := err.(*ErrorChallenge)
+	if !ok {
+		ec = &ErrorChallenge{Status: "401", Schemes: "Bearer"}
+	}
+	return json.Marshal(ec)
+}
@@ -0,0 +1,201 @@
+// Package plain implements PLAIN mechanism described in RFC 4616.
+// Password is sent in clear text, so mechanism should be used over TLS only
+package plain
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/saslprep"
+)
+
+// Maximum length in bytes of authzid, authcid and passwd
+const MAX_LENGTH = 255
+
+func init() {
+	sasl.Register(&sasl.Mechanism{
+		Name:      "PLAIN",
+		Plaintext: true,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			return NewClient(cfg.Username, cfg.Password, cfg.AuthID), nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			s := NewServer()
+			s.SetPasswordStore(cfg.Passwords)
+			return s, nil
+		},
+	})
+}
+
+type Client struct {
+	auth_id, username, password string
+	step                        int
+}
+
+// Creates client authenticating as username and optionally requesting auth_id authorization identity
+func NewClient(username, password, auth_id string) *Client {
+	return &Client{username: username, password: password, auth_id: auth_id}
+}
+
+// Generates client's message. User name and password will be SASLprepared
+func (c *Client) Response() ([]byte, error) {
+	username, err := saslprep.Prepare(c.username)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := saslprep.Prepare(c.password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validate([]byte(c.auth_id), []byte(username), []byte(password)); err != nil {
+		return nil, err
+	}
+
+	return bytes.Join([][]byte{[]byte(c.auth_id), []byte(username), []byte(password)}, []byte{0}), nil
+}
+
+// Implements sasl.ClientMechanism. Response is sent as initial response or as reply to empty challenge
+func (c *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch c.step {
+	case 0:
+		if len(challenge) != 0 {
+			return nil, false, WrongServerMessage("Unexpected challenge")
+		}
+		resp, err := c.Response()
+		if err != nil {
+			return nil, false, err
+		}
+		c.step++
+		return resp, false, nil
+	case 1:
+		c.step++
+		return nil, true, nil
+	}
+
+	return nil, false, WrongServerMessage("Authentication exchange is already completed")
+}
+
+type Server struct {
+	auth_id, username, password []byte
+	store                       sasl.PasswordStore
+	step                        int
+	failed                      bool
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Sets passwords store used to authenticate users with Step
+func (s *Server) SetPasswordStore(store sasl.PasswordStore) {
+	s.store = store
+}
+
+// Returns AuthID for current authentication session.
+// If client didn't provide AuthID - UserName will be used
+func (s *Server) AuthID() string {
+	if len(s.auth_id) != 0 {
+		return string(s.auth_id)
+	}
+	return string(s.username)
+}
+
+// Returns UserName provided by client
+func (s *Server) UserName() string {
+	return string(s.username)
+}
+
+// Parses client's message checking its format. User name and password will be SASLprepared
+func (s *Server) ParseResponse(response []byte) error {
+	parts := bytes.Split(response, []byte{0})
+	if len(parts) != 3 {
+		return WrongClientMessage("Message should contain exactly two NUL separators")
+	}
+
+	if err := validate(parts[0], parts[1], parts[2]); err != nil {
+		return err
+	}
+
+	username, err := saslprep.Prepare(string(parts[1]))
+	if err != nil {
+		return err
+	}
+
+	password, err := saslprep.Prepare(string(parts[2]))
+	if err != nil {
+		return err
+	}
+
+	s.auth_id, s.username, s.password = parts[0], []byte(username), []byte(password)
+	return nil
+}
+
+// Checks password provided by client. Password will be SASLprepared before comparison
+func (s *Server) Validate(password string) error {
+	prepared, err := saslprep.Prepare(password)
+	if err != nil {
+		return err
+	}
+
+	if !sasl.SecureEqual([]byte(prepared), s.password) {
+		return WrongClientMessage("Wrong password provided")
+	}
+	return nil
+}
+
+// Implements sasl.ServerMechanism. SetPasswordStore should be called before this method usage.
+// PLAIN has the only response, so exchange ends once it is rejected
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	switch {
+	case s.failed:
+		return nil, false, WrongClientMessage("Authentication has already failed")
+	case s.step != 0:
+		return nil, false, WrongClientMessage("Authentication exchange is already completed")
+	}
+
+	if len(response) == 0 {
+		// Client didn't send initial response, ask for it with empty challenge
+		return []byte{}, false, nil
+	}
+
+	if err := s.authenticate(response); err != nil {
+		s.failed = true
+		return nil, false, err
+	}
+
+	s.step++
+	return nil, true, nil
+}
+
+func (s *Server) authenticate(response []byte) error {
+	if err := s.ParseResponse(response); err != nil {
+		return err
+	}
+
+	if s.store == nil {
+		return errors.New("Password store is not set")
+	}
+	password, err := s.store.Password(s.UserName())
+	if err != nil {
+		return err
+	}
+
+	return s.Validate(password)
+}
+
+func validate(auth_id, username, password []byte) error {
+	switch {
+	case len(username) == 0:
+		return WrongClientMessage("Authentication identity is empty")
+	case len(password) == 0:
+		return WrongClientMessage("Password is empty")
+	case len(auth_id) > MAX_LENGTH || len(username) > MAX_LENGTH || len(password) > MAX_LENGTH:
+		return WrongClientMessage("Value is too long")
+	case bytes.IndexByte(auth_id, 0) >= 0 || bytes.IndexByte(username, 0) >= 0 || bytes.IndexByte(password, 0) >= 0:
+		return WrongClientMessage("Value contains NUL character")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package plain
+
+import (
+	"strings"
+	"testing"
+)
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	return p[username], nil
+}
+
+func TestResponse(t *testing.T) {
+	resp, err := NewClient("tim", "tanstaaftanstaaf", "").Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "\x00tim\x00tanstaaftanstaaf" {
+		t.Fatalf("Wrong response generated: %q", resp)
+	}
+
+	resp, err = NewClient("Ursel", "kurt ", "Kurt").Response()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "Kurt\x00Ursel\x00kurt " {
+		t.Fatalf("Password should be SASLprepared: %q", resp)
+	}
+
+	if _, err := NewClient("tim", "", "").Response(); err == nil {
+		t.Fatal("Empty password should be rejected")
+	}
+	if _, err := NewClient("tim\x00", "secret", "").Response(); err == nil {
+		t.Fatal("NUL in user name should be rejected")
+	}
+	if _, err := NewClient("tim", strings.Repeat("x", 256), "").Response(); err == nil {
+		t.Fatal("Too long password should be rejected")
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	s := NewServer()
+	if err := s.ParseResponse([]byte("admin\x00tim\x00tanstaaftanstaaf")); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserName() != "tim" || s.AuthID() != "admin" {
+		t.Fatal("Wrong identities parsed:", s.UserName(), s.AuthID())
+	}
+	if err := s.Validate("tanstaaftanstaaf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate("wrong"); err == nil {
+		t.Fatal("Wrong password should be rejected")
+	}
+
+	for _, resp := range []string{"tim\x00secret", "\x00\x00secret", "\x00tim\x00", "\x00tim\x00sec\x00ret", "\x00tim\x00sec\u0007"} {
+		if err := NewServer().ParseResponse([]byte(resp)); err == nil {
+			t.Fatalf("Malformed response %q should be rejected", resp)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	c := NewClient("tim", "tanstaaftanstaaf", "")
+	s := NewServer()
+	s.SetPasswordStore(passwords{"tim": "tanstaaftanstaaf"})
+
+	resp, done, err := c.Step(nil)
+	if err != nil || done {
+		t.Fatal("Client should send initial response", err)
+	}
+
+	if chal, done, err := s.Step(nil); err != nil || done || len(chal) != 0 {
+		t.Fatal("Server should ask for response with empty challenge", err)
+	}
+
+	if _, done, err := s.Step(resp); err != nil || !done {
+		t.Fatal("Authentication failed", err)
+	}
+
+	if _, done, err := c.Step(nil); err != nil || !done {
+		t.Fatal("Client should complete authentication", err)
+	}
+}
+
+func TestStepFailure(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.Step([]byte("\x00tim\x00tanstaaftanstaaf")); err == nil {
+		t.Fatal("Server without store should fail")
+	}
+
+	s = NewServer()
+	s.SetPasswordStore(passwords{"tim": "tanstaaftanstaaf"})
+	if _, _, err := s.Step([]byte("\x00tim\x00wrong")); err == nil {
+		t.Fatal("Wrong password should be rejected")
+	}
+	if _, _, err := s.Step([]byte("\x00tim\x00tanstaaftanstaaf")); err == nil {
+		t.Fatal("Failed exchange should not be continued")
+	}
+}
+
+func TestClientServerErrors(t *testing.T) {
+	c := NewClient("tim", "tanstaaftanstaaf", "")
+	if _, _, err := c.Step([]byte("challenge")); err == nil {
+		t.Fatal("Non-empty challenge should be rejected")
+	} else if _, ok := err.(WrongServerMessage); !ok {
+		t.Fatal("Server's message error expected:", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package saslprep implements SASLprep profile of stringprep described in RFC 4013
+package saslprep
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Prohibited output characters, see RFC 4013 section 2.3
+var prohibited = []*unicode.RangeTable{
+	nonASCIISpace, asciiControl, nonASCIIControl, privateUse, nonCharacter,
+	surrogate, inappropriatePlain, inappropriateCanonical, displayChanging, tagging,
+}
+
+type ProhibitedCharacter rune
+
+func (pc ProhibitedCharacter) Error() string {
+	return fmt.Sprintf("Prohibited character U+%04X", rune(pc))
+}
+
+type UnassignedCharacter rune
+
+func (uc UnassignedCharacter) Error() string {
+	return fmt.Sprintf("Unassigned character U+%04X", rune(uc))
+}
+
+type BidiViolation string
+
+func (bv BidiViolation) Error() string {
+	return fmt.Sprintf("Bidirectional text requirements violated: %s", string(bv))
+}
+
+// Byte offset of invalid UTF-8 sequence. Input itself is not reported, since it may be a password
+type InvalidUTF8 int
+
+func (iu InvalidUTF8) Error() string {
+	return fmt.Sprintf("Invalid UTF-8 sequence at byte %d", int(iu))
+}
+
+// Prepares string treating it as query, so unassigned code points are allowed.
+// This is the way user names and passwords should be prepared during authentication
+func Prepare(s string) (string, error) {
+	return prepare(s, true)
+}
+
+// Prepares string treating it as stored string, so unassigned code points are prohibited.
+// Should be used for strings which are going to be stored, e.g. during registration
+func PrepareStored(s string) (string, error) {
+	return prepare(s, false)
+}
+
+func prepare(s string, allow_unassigned bool) (string, error) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return "", InvalidUTF8(i)
+		}
+		i += size
+	}
+
+	s = norm.NFKC.String(mapChars(s))
+
+	var has_randal, has_l bool
+	for _, r := range s {
+		for _, table := range prohibited {
+			if unicode.Is(table, r) {
+				return "", ProhibitedCharacter(r)
+			}
+		}
+
+		if !allow_unassigned && unicode.Is(unassigned, r) {
+			return "", UnassignedCharacter(r)
+		}
+
+		has_randal = has_randal || unicode.Is(randALCat, r)
+		has_l = has_l || unicode.Is(lCat, r)
+	}
+
+	if has_randal {
+		if has_l {
+			return "", BidiViolation("RandALCat and LCat characters are mixed")
+		}
+
+		first, _ := utf8.DecodeRuneInString(s)
+		last, _ := utf8.DecodeLastRuneInString(s)
+		if !unicode.Is(randALCat, first) || !unicode.Is(randALCat, last) {
+			return "", BidiViolation("RandALCat string should start and end with RandALCat character")
+		}
+	}
+
+	return s, nil
+}
+
+// Maps non-ASCII spaces to SPACE and removes characters commonly mapped to nothing
+func mapChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.Is(nonASCIISpace, r):
+			return ' '
+		case unicode.Is(mappedToNothing, r):
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,67 @@
+package saslprep
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	// Examples from RFC 4013 section 3
+	examples := []struct {
+		in, out string
+	}{
+		{"I\u00ADX", "IX"},
+		{"user", "user"},
+		{"USER", "USER"},
+		{"\u00AA", "a"},
+		{"\u2168", "IX"},
+		{"a\u00A0b", "a b"},
+		{"e\u0301", "\u00E9"},
+	}
+
+	for _, ex := range examples {
+		out, err := Prepare(ex.in)
+		if err != nil {
+			t.Fatalf("Preparing %q failed: %s", ex.in, err)
+		}
+		if out != ex.out {
+			t.Fatalf("Prepare(%q) = %q, expected %q", ex.in, out, ex.out)
+		}
+	}
+}
+
+func TestPrepareErrors(t *testing.T) {
+	if _, err := Prepare("\u0007"); err != ProhibitedCharacter(0x07) {
+		t.Fatal("Control character should be prohibited, got", err)
+	}
+
+	if _, err := Prepare("\u06271"); err == nil {
+		t.Fatal("RandALCat string should end with RandALCat character")
+	} else if _, ok := err.(BidiViolation); !ok {
+		t.Fatal("Wrong error type", err)
+	}
+
+	if _, err := Prepare("\u0627a\u0627"); err == nil {
+		t.Fatal("RandALCat and LCat characters should not be mixed")
+	}
+
+	if out, err := Prepare("\u06271\u0628"); err != nil || out != "\u06271\u0628" {
+		t.Fatal("Valid RandALCat string was not prepared", err)
+	}
+
+	if _, err := Prepare("\u0221"); err != nil {
+		t.Fatal("Unassigned code points should be allowed in query", err)
+	}
+
+	if _, err := PrepareStored("\u0221"); err != UnassignedCharacter(0x221) {
+		t.Fatal("Unassigned code points should be prohibited in stored string, got", err)
+	}
+
+	_, err := Prepare("secret\xff")
+	if err != InvalidUTF8(6) {
+		t.Fatal("Invalid UTF-8 should be rejected with its offset, got", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatal("Error should not contain input:", err)
+	}
+}
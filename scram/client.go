@@ -6,37 +6,145 @@ import (
 	"strconv"
 
 	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/saslprep"
 )
 
 type Client struct {
 	*scram
+
+	user     string // User name used by Step
+	password []byte // Password used by Step
 }
 
 func NewClient(h HashConstructor, gen sasl.SaltGenerator) *Client {
-	return &Client{newScram(h, false, gen)}
+	return &Client{scram: newScram(h, false, gen)}
+}
+
+// Sets user name and password used to authenticate with Step
+func (s *Client) SetCredentials(username, password string) {
+	s.user = username
+	s.password = []byte(password)
+}
+
+// Creates client for -PLUS variant of mechanism binding authentication to provided channel
+func NewClientPlus(h HashConstructor, gen sasl.SaltGenerator, cb *sasl.ChannelBinding) *Client {
+	c := &Client{scram: newScram(h, true, gen)}
+	c.SetChannelBinding(cb)
+	return c
+}
+
+// Sets channel binding used by client. If nil is provided client will only
+// report that it supports binding. It should be used when server didn't
+// advertise -PLUS variant of mechanism
+func (s *Client) SetChannelBinding(cb *sasl.ChannelBinding) {
+	if cb == nil {
+		s.binding, s.cb_name, s.cb_data = 'y', nil, nil
+		return
+	}
+	s.binding, s.cb_name, s.cb_data = 'p', []byte(cb.Type), cb.Data
 }
 
-// Generates Client First message. Username whould be SASLprepared
-func (s *Client) First(username string) []byte {
-	s.username = prepare(username)
-	return append(s.bindString(), s.bareClientFirst()...)
+// Sets authorization identity sent in Client First message
+func (s *Client) SetAuthID(auth_id string) {
+	s.auth_id = []byte(auth_id)
 }
 
-// Generated Client Final message. SaltPassword should be called before this method usage
-func (s *Client) Final() []byte {
-	return sasl.MakeMessage(s.clientReplyNotProof(), makeKeyValue('p', sasl.Base64ToBytes(s.proof())))
+// Implements sasl.ClientMechanism. SetCredentials should be called before this method usage
+func (s *Client) Step(challenge []byte) ([]byte, bool, error) {
+	switch s.state {
+	case STATE_INITIAL:
+		if len(challenge) != 0 {
+			return nil, false, s.fail(WrongServerMessage("Unexpected initial challenge"))
+		}
+		first, err := s.First(s.user)
+		if err != nil {
+			return nil, false, err
+		}
+		return first, false, nil
+	case STATE_FIRST_SENT:
+		if err := s.ParseServerFirst(challenge); err != nil {
+			return nil, false, err
+		}
+		if _, err := s.SaltPassword(s.password); err != nil {
+			return nil, false, s.fail(err)
+		}
+		final, err := s.Final()
+		if err != nil {
+			return nil, false, err
+		}
+		return final, false, nil
+	case STATE_FINAL_SENT:
+		if err := s.CheckServerFinal(challenge); err != nil {
+			return nil, false, err
+		}
+		return nil, true, nil
+	}
+
+	return nil, false, s.expectState("Step")
+}
+
+// Generates Client First message. Username will be SASLprepared
+func (s *Client) First(username string) ([]byte, error) {
+	if err := s.expectState("First", STATE_INITIAL); err != nil {
+		return nil, err
+	}
+
+	prepared, err := saslprep.Prepare(username)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+
+	if err := s.generateCNonce(); err != nil {
+		return nil, s.fail(err)
+	}
+
+	s.username = []byte(prepared)
+	s.client_first_bare = s.bareClientFirst()
+	s.state = STATE_FIRST_SENT
+	return append(s.bindString(), s.client_first_bare...), nil
+}
+
+// Generated Client Final message. ParseServerFirst and SaltPassword should be called before this method usage
+func (s *Client) Final() ([]byte, error) {
+	if err := s.expectState("Final", STATE_FIRST_SENT); err != nil {
+		return nil, err
+	}
+	if err := s.require("Final", len(s.server_nonce) > 0, "Server First should be parsed first"); err != nil {
+		return nil, err
+	}
+	if err := s.require("Final", len(s.salted_password) > 0, "Salt password first"); err != nil {
+		return nil, err
+	}
+
+	s.client_final_bare = s.clientReplyNotProof()
+	s.state = STATE_FINAL_SENT
+	return sasl.MakeMessage(s.client_final_bare, makeKeyValue('p', sasl.Base64ToBytes(s.proof()))), nil
 }
 
 // Parses Server First message and populates Scram's internal fields
-// like server nonce, salt, iterations count
+// like server nonce, salt, iterations count.
+// Unknown attributes are passed to extension handlers and are available with Extension
 func (s *Client) ParseServerFirst(server_first []byte) error {
-	return sasl.EachToken(server_first, ',', func(token []byte) error {
-		k, v := sasl.ExtractKeyValue(token, '=')
-		if len(k) != 1 {
+	if err := s.expectState("ParseServerFirst", STATE_FIRST_SENT); err != nil {
+		return err
+	}
+	if err := s.require("ParseServerFirst", len(s.server_nonce) == 0, "Server First was already parsed"); err != nil {
+		return err
+	}
+
+	return s.fail(s.parseServerFirst(server_first))
+}
+
+func (s *Client) parseServerFirst(server_first []byte) error {
+	s.server_first_msg = sasl.MakeCopy(server_first)
+
+	err := sasl.EachToken(server_first, ',', func(token []byte) error {
+		k, v, ok := splitAttribute(token)
+		if !ok {
 			return WrongServerMessage("Wrong key/value pair")
 		}
 
-		switch k[0] {
+		switch k {
 		case 'i':
 			it, err := strconv.Atoi(string(v))
 			if err != nil {
@@ -47,19 +155,55 @@ func (s *Client) ParseServerFirst(server_first []byte) error {
 			s.server_nonce = v
 		case 's':
 			salt := make([]byte, base64.StdEncoding.DecodedLen(len(v)))
-			if _, err := base64.StdEncoding.Decode(salt, v); err != nil {
+			n, err := base64.StdEncoding.Decode(salt, v)
+			if err != nil {
 				return err
 			}
-			s.salt = salt
+			s.salt = salt[:n]
 		default:
-			return WrongServerMessage("Unknown value provided")
+			return s.parseExtension(k, v)
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(s.server_nonce) <= len(s.client_nonce) || !bytes.HasPrefix(s.server_nonce, s.client_nonce):
+		return WrongServerMessage("Nonce should start with client's nonce")
+	case len(s.salt) == 0:
+		return WrongServerMessage("Salt is missing")
+	case s.iterate <= 0:
+		return WrongServerMessage("Wrong iterations count")
+	}
+	return nil
 }
 
-// Checks Server Final message verifying server signature
+// Checks Server Final message verifying server signature.
+// If server reported an error ServerError is returned
 func (s *Client) CheckServerFinal(sfinal []byte) error {
+	if err := s.expectState("CheckServerFinal", STATE_FINAL_SENT); err != nil {
+		return err
+	}
+
+	if err := s.fail(s.checkServerFinal(sfinal)); err != nil {
+		return err
+	}
+
+	s.state = STATE_DONE
+	return nil
+}
+
+func (s *Client) checkServerFinal(sfinal []byte) error {
+	if bytes.HasPrefix(sfinal, []byte{'e', '='}) {
+		e, err := extractParameter(sfinal, 'e')
+		if err != nil {
+			return err
+		}
+		return ServerError(e)
+	}
+
 	b64ver, err := extractParameter(sfinal, 'v')
 	if err != nil {
 		return err
@@ -70,7 +214,7 @@ func (s *Client) CheckServerFinal(sfinal []byte) error {
 		return err
 	}
 
-	if !bytes.Equal(s.verification(), verification) {
+	if !secureEqual(s.verification(), verification) {
 		return WrongServerMessage("Wrong verification provided")
 	}
 	return nil
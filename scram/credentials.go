@@ -0,0 +1,38 @@
+package scram
+
+import "github.com/goxmpp/sasl"
+
+// Credentials server needs to authenticate user. Unlike salted password
+// they can't be used to impersonate user against other servers
+type Credentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// Used by server to get stored credentials of the user.
+// ERR_UNKNOWN_USER should be returned if user doesn't exist, so it could be reported to client
+type CredentialStore interface {
+	Credentials(username string) (*Credentials, error)
+}
+
+// Calculates credentials which should be stored for user's password.
+// Salt and Iterations count are taken from generator, nil can be provided - then default generator will be used
+func NewCredentials(h HashConstructor, gen sasl.SaltGenerator, password []byte) (*Credentials, error) {
+	s := newScram(h, false, gen)
+	if _, err := s.SaltPassword(password); err != nil {
+		return nil, err
+	}
+
+	return s.credentials(), nil
+}
+
+func (s *scram) credentials() *Credentials {
+	return &Credentials{
+		Salt:       sasl.MakeCopy(s.salt),
+		Iterations: s.iterations(),
+		StoredKey:  sasl.MakeCopy(s.storedKey()),
+		ServerKey:  sasl.MakeCopy(s.serverKey()),
+	}
+}
@@ -8,8 +8,57 @@ func (wcm WrongClientMessage) Error() string {
 	return fmt.Sprintf("Wrong Client Message Provided: %s", string(wcm))
 }
 
+func (wcm WrongClientMessage) MalformedRequest() bool {
+	return true
+}
+
 type WrongServerMessage string
 
 func (wsm WrongServerMessage) Error() string {
 	return fmt.Sprintf("Wrong Server Message Provided: %s", string(wsm))
 }
+
+// Error reported by server with 'e' attribute of Server Final message, see RFC 5802 section 7.
+// Values not listed below are extensions and should be treated as ERR_OTHER
+type ServerError string
+
+const (
+	ERR_INVALID_ENCODING                    ServerError = "invalid-encoding"
+	ERR_EXTENSIONS_NOT_SUPPORTED            ServerError = "extensions-not-supported"
+	ERR_INVALID_PROOF                       ServerError = "invalid-proof"
+	ERR_CHANNEL_BINDINGS_DONT_MATCH         ServerError = "channel-bindings-dont-match"
+	ERR_SERVER_DOES_SUPPORT_CHANNEL_BINDING ServerError = "server-does-support-channel-binding"
+	ERR_CHANNEL_BINDING_NOT_SUPPORTED       ServerError = "channel-binding-not-supported"
+	ERR_UNSUPPORTED_CHANNEL_BINDING_TYPE    ServerError = "unsupported-channel-binding-type"
+	ERR_UNKNOWN_USER                        ServerError = "unknown-user"
+	ERR_INVALID_USERNAME_ENCODING           ServerError = "invalid-username-encoding"
+	ERR_NO_RESOURCES                        ServerError = "no-resources"
+	ERR_OTHER                               ServerError = "other-error"
+)
+
+func (se ServerError) Error() string {
+	return fmt.Sprintf("Server Error: %s", string(se))
+}
+
+func (se ServerError) MalformedRequest() bool {
+	return se == ERR_INVALID_USERNAME_ENCODING || se == ERR_EXTENSIONS_NOT_SUPPORTED
+}
+
+func (se ServerError) IncorrectEncoding() bool {
+	return se == ERR_INVALID_ENCODING
+}
+
+func (se ServerError) TemporaryFailure() bool {
+	return se == ERR_NO_RESOURCES
+}
+
+// Returns ServerError which should be reported to client for err
+func serverErrorOf(err error) ServerError {
+	switch e := err.(type) {
+	case ServerError:
+		return e
+	case UnsupportedExtension:
+		return ERR_EXTENSIONS_NOT_SUPPORTED
+	}
+	return ERR_OTHER
+}
@@ -0,0 +1,73 @@
+package scram
+
+import (
+	"fmt"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Attribute reserved for mandatory extensions by RFC 5802
+const MANDATORY_EXTENSION = 'm'
+
+// Returned when mandatory extension is received, but no handler is registered for it
+type UnsupportedExtension byte
+
+func (ue UnsupportedExtension) Error() string {
+	return fmt.Sprintf("Unsupported mandatory extension: %c", byte(ue))
+}
+
+func (ue UnsupportedExtension) MalformedRequest() bool {
+	return true
+}
+
+// Called when extension attribute is received from other side.
+// Returned error aborts authentication
+type ExtensionHandler func(value []byte) error
+
+// Registers handler of extension attribute received in First or Final messages.
+// Registering handler for MANDATORY_EXTENSION makes it acceptable
+func (s *scram) HandleExtension(key byte, handler ExtensionHandler) {
+	if s.handlers == nil {
+		s.handlers = make(map[byte]ExtensionHandler)
+	}
+	s.handlers[key] = handler
+}
+
+// Adds extension attribute to First message sent to other side
+func (s *scram) SetExtension(key byte, value []byte) {
+	s.out_extensions = append(s.out_extensions, makeKeyValue(key, value))
+}
+
+// Returns value of extension attribute received from other side
+func (s *scram) Extension(key byte) ([]byte, bool) {
+	value, ok := s.extensions[key]
+	return value, ok
+}
+
+// Returns all extension attributes received from other side
+func (s *scram) Extensions() map[byte][]byte {
+	exts := make(map[byte][]byte, len(s.extensions))
+	for k, v := range s.extensions {
+		exts[k] = sasl.MakeCopy(v)
+	}
+	return exts
+}
+
+// Stores extension attribute and calls its handler if any.
+// Mandatory extensions without handler are rejected
+func (s *scram) parseExtension(key byte, value []byte) error {
+	handler, ok := s.handlers[key]
+	if key == MANDATORY_EXTENSION && !ok {
+		return UnsupportedExtension(key)
+	}
+
+	if s.extensions == nil {
+		s.extensions = make(map[byte][]byte)
+	}
+	s.extensions[key] = value
+
+	if ok {
+		return handler(value)
+	}
+	return nil
+}
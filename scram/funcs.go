@@ -19,16 +19,27 @@ func extractProof(mess []byte) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(string(b64proof))
 }
 
+// Used for all comparisons of secret-derived values. Variable allows tests to ensure it's used
+var secureEqual = sasl.SecureEqual
+
 func extractParameter(mess []byte, param byte) ([]byte, error) {
 	return sasl.ExtractParameter(mess, []byte{param})
 }
 
+// Splits attribute into its single letter name and value
+func splitAttribute(token []byte) (byte, []byte, bool) {
+	if len(token) < 2 || token[1] != '=' {
+		return 0, nil, false
+	}
+	return token[0], token[2:], true
+}
+
 func makeKeyValue(key byte, value []byte) []byte {
 	return sasl.MakeKeyValue([]byte{key}, value)
 }
 
 func validateMessage(mess []byte) error {
-	if mess[0] != 'y' && mess[0] != 'n' && mess[0] != 'p' {
+	if len(mess) == 0 || mess[0] != 'y' && mess[0] != 'n' && mess[0] != 'p' {
 		return WrongClientMessage("Wrong start byte")
 	}
 	return nil
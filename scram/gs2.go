@@ -0,0 +1,63 @@
+package scram
+
+import (
+	"bytes"
+
+	"github.com/goxmpp/sasl"
+)
+
+// GS2 header starting client's first message of SCRAM and other
+// GS2-compatible mechanisms (e.g. OAUTHBEARER), see RFC 5801 section 4
+type GS2Header struct {
+	Flag   byte   // Channel binding flag: 'n', 'y' or 'p'
+	CBName []byte // Channel binding type used with 'p' flag
+	AuthID []byte // Authorization identity, not escaped
+}
+
+// Returns header in wire format including trailing comma
+func (h *GS2Header) Bytes() []byte {
+	// Client first message should start with 'n', 'y' or 'p'
+	// otherwise it should be treated as invalid
+	bind := []byte{h.Flag}
+	if h.Flag == 'p' {
+		bind = append(append(bind, '='), h.CBName...)
+	}
+	bind = append(bind, ',')
+	if len(h.AuthID) > 0 {
+		bind = append(bind, makeKeyValue('a', prepare(string(h.AuthID)))...)
+	}
+	return append(bind, ',')
+}
+
+// Parses GS2 header of message and returns it with the rest of message
+func ParseGS2Header(mess []byte) (*GS2Header, []byte, error) {
+	if err := validateMessage(mess); err != nil {
+		return nil, nil, err
+	}
+
+	// GS2 header consists of binding flag and optional authorization identity
+	parts := bytes.SplitN(mess, []byte{','}, 3)
+	if len(parts) != 3 {
+		return nil, nil, WrongClientMessage("Wrong GS2 header")
+	}
+
+	h := &GS2Header{}
+	switch flag := parts[0]; {
+	case len(flag) == 1 && (flag[0] == 'n' || flag[0] == 'y'):
+		h.Flag = flag[0]
+	case bytes.HasPrefix(flag, []byte{'p', '='}) && len(flag) > 2:
+		h.Flag, h.CBName = 'p', sasl.MakeCopy(flag[2:])
+	default:
+		return nil, nil, WrongClientMessage("Wrong binding flag")
+	}
+
+	switch auth_id := parts[1]; {
+	case len(auth_id) == 0:
+	case bytes.HasPrefix(auth_id, []byte{'a', '='}):
+		h.AuthID = deprepare(sasl.MakeCopy(auth_id[2:]))
+	default:
+		return nil, nil, WrongClientMessage("Wrong authorization identity")
+	}
+
+	return h, parts[2], nil
+}
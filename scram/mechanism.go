@@ -0,0 +1,65 @@
+package scram
+
+import "github.com/goxmpp/sasl"
+
+func init() {
+	for _, v := range Variants {
+		sasl.Register(newMechanism(v, false))
+		sasl.Register(newMechanism(v, true))
+	}
+}
+
+func newMechanism(v *Variant, plus bool) *sasl.Mechanism {
+	name := v.Name
+	if plus {
+		name = v.PlusName()
+	}
+
+	return &sasl.Mechanism{
+		Name:           name,
+		HashBits:       v.Hash().Size() * 8,
+		ChannelBinding: plus,
+		NewClient: func(cfg *sasl.Config) (sasl.ClientMechanism, error) {
+			var c *Client
+			switch {
+			case plus && len(cfg.ChannelBindings) == 0:
+				return nil, sasl.UnsupportedChannelBinding(name)
+			case plus:
+				c = v.NewClientPlus(cfg.Generator, cfg.ChannelBindings[0])
+			default:
+				c = v.NewClient(cfg.Generator)
+				if len(cfg.ChannelBindings) > 0 {
+					// Binding is supported, but server didn't offer -PLUS mechanism
+					c.SetChannelBinding(nil)
+				}
+			}
+			if len(cfg.Advertised) > 0 {
+				c.SetDowngradeProtection(cfg.Advertised, cfg.AdvertisedBindings)
+			}
+			c.SetCredentials(cfg.Username, cfg.Password)
+			c.SetAuthID(cfg.AuthID)
+			return c, nil
+		},
+		NewServer: func(cfg *sasl.Config) (sasl.ServerMechanism, error) {
+			var s *Server
+			switch {
+			case plus && len(cfg.ChannelBindings) == 0:
+				return nil, sasl.UnsupportedChannelBinding(name)
+			case plus:
+				s = v.NewServerPlus(cfg.Generator, cfg.ChannelBindings...)
+			default:
+				s = v.NewServer(cfg.Generator)
+				s.SetChannelBindings(cfg.ChannelBindings...)
+			}
+			if len(cfg.Advertised) > 0 {
+				s.SetDowngradeProtection(cfg.Advertised, cfg.AdvertisedBindings)
+			}
+			s.SetPasswordStore(cfg.Passwords)
+			// Stores able to provide StoredKey and ServerKey are preferred to passwords
+			if store, ok := cfg.Passwords.(CredentialStore); ok {
+				s.SetCredentialStore(store)
+			}
+			return s, nil
+		},
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/saslprep"
 )
 
 const (
@@ -26,68 +27,101 @@ type scram struct {
 	gen  sasl.SaltGenerator // Salt, Nonce and Iterations generator
 
 	salted_password []byte // Salted password
+	stored_key      []byte // H(ClientKey), used by server to verify proof
+	server_key      []byte // Key used by server to sign Server Final message
 	salt            []byte // Salt generated by server
 	iterate         int    // Number of iterations during password salting
+	min_iterations  int    // Minimum number of iterations used for generated iterations count
 	client_nonce    []byte // Client's nonce
 	server_nonce    []byte // Server's nonce concatenated to client's nonce
 	proof_sig       []byte // Proof calculated from salted password
 	username        []byte // User name provided in Client First message
 	auth_id         []byte // Authorization identity usually empty
 	binding         byte   // binding indicator used for GS2
+	plus            bool   // true for -PLUS variant of mechanism which requires channel binding
+	cb_name         []byte // Channel binding type used with 'p' GS2 flag
+	cb_data         []byte // Channel binding data of cb_name type
+
+	bindings []*sasl.ChannelBinding // Channel bindings server is able to verify
+
+	client_first_bare []byte // Client First message without GS2 header as it was sent
+	server_first_msg  []byte // Server First message as it was sent
+	client_final_bare []byte // Client Final message without proof as it was sent
+
+	extensions     map[byte][]byte           // Extension attributes received from other side
+	out_extensions [][]byte                  // Extension attributes added to First message
+	handlers       map[byte]ExtensionHandler // Handlers of extension attributes
+
+	state State // State of authentication session
 }
 
 // Created new object that can be used for authentication session.
 // Requires:
 // - Hash function constructor
-// - boolean to specify if channel binding is supported. Binding is not really implemented yet
+// - boolean to specify if -PLUS variant of mechanism is used, so channel binding is required
 // - optional generator object. nil can be provided - then default generator will be used
 func newScram(cons HashConstructor, use_binding bool, gen sasl.SaltGenerator) *scram {
 	if gen == nil {
 		gen = DefaultGenerator
 	}
 
-	var binding byte = 'n'
-	if use_binding {
-		binding = 'p'
-	}
-
-	return &scram{cons: cons, gen: gen, binding: binding}
+	return &scram{cons: cons, gen: gen, binding: 'n', plus: use_binding}
 }
 
 // Returns true if channel binding is supported
 func (s *scram) BindingSupported() bool {
-	return s.binding == 'y'
+	return s.binding == 'y' || s.binding == 'p'
+}
+
+// Returns channel binding type used in authentication session if any
+func (s *scram) BindingType() string {
+	return string(s.cb_name)
 }
 
 // Sets salt, salted_password and iterations count for further processing.
 // This method allows to have salted password and related info stored and
-// provided at authentications stage. So you won't need to store it in plain text
+// provided at authentications stage. So you won't need to store it in plain text.
+// Salted password is equivalent of password, so servers should use Credentials instead
 func (s *scram) SetSaltedPassword(spassword []byte, salt []byte, iterations int) {
 	s.iterate = iterations
 	s.salted_password = spassword
 	s.salt = salt
+	s.stored_key, s.server_key = nil, nil
 }
 
 // Gererates (if necessary) and returns salt as slice of bites.
 // If Salt was parsed from Server First message - just returns salt parsed from that message
-func (s *scram) Salt() []byte {
+func (s *scram) Salt() ([]byte, error) {
 	if len(s.salt) == 0 {
-		s.salt = s.gen.GetSalt(SALT_BYTES)
+		salt, err := s.gen.GetSalt(SALT_BYTES)
+		if err != nil {
+			return nil, err
+		}
+		s.salt = salt
 	}
 
 	// Return a copy of generated salt, so user can modify it as she wants
-	return sasl.MakeCopy(s.salt)
+	return sasl.MakeCopy(s.salt), nil
 }
 
 // Salts password and retrun salted password as slice of bytes.
+// Password will be SASLprepared before salting.
 // Salt and Iterations values will be generated as needed
 // if they were not parsed from Server First message
-func (s *scram) SaltPassword(password []byte) []byte {
-	mac := hmac.New(s.cons, password)
+func (s *scram) SaltPassword(password []byte) ([]byte, error) {
+	prepared, err := saslprep.Prepare(string(password))
+	if err != nil {
+		return nil, err
+	}
 
-	salt := s.Salt()
+	salt, err := s.Salt()
+	if err != nil {
+		return nil, err
+	}
 	salt = append(salt, 0x00, 0x00, 0x00, 0x01)
 
+	mac := hmac.New(s.cons, []byte(prepared))
+
 	prev := salt
 	var result []byte
 	for i := 0; i < s.iterations(); i++ {
@@ -104,8 +138,9 @@ func (s *scram) SaltPassword(password []byte) []byte {
 	}
 
 	s.salted_password = result
+	s.stored_key, s.server_key = nil, nil
 
-	return sasl.MakeCopy(s.salted_password)
+	return sasl.MakeCopy(s.salted_password), nil
 }
 
 func (s *scram) checkBinding(client_final []byte) error {
@@ -114,74 +149,133 @@ func (s *scram) checkBinding(client_final []byte) error {
 		return err
 	}
 
-	if !bytes.Equal(sasl.Base64ToBytes(s.bindString()), bind) {
-		return WrongClientMessage("Invalid binding specified")
+	if !secureEqual(sasl.Base64ToBytes(s.channelBinding()), bind) {
+		return ERR_CHANNEL_BINDINGS_DONT_MATCH
 	}
 
 	return nil
 }
 
-// Check's that received proof matches expected one
-func (s *scram) checkProof(proof []byte) bool {
-	if len(s.salted_password) == 0 {
-		panic("Salt password first") // TODO refactor this
+// Checks GS2 flag received from client against channel bindings server supports
+// and selects channel binding data requested by client
+func (s *scram) selectBinding() error {
+	switch s.binding {
+	case 'p':
+		if !s.plus {
+			return ERR_CHANNEL_BINDING_NOT_SUPPORTED
+		}
+		for _, cb := range s.bindings {
+			if bytes.Equal([]byte(cb.Type), s.cb_name) {
+				s.cb_data = cb.Data
+				return nil
+			}
+		}
+		return ERR_UNSUPPORTED_CHANNEL_BINDING_TYPE
+	case 'y':
+		// Client thinks server doesn't support binding, but it does. Possible downgrade attack
+		if s.plus || len(s.bindings) > 0 {
+			return ERR_SERVER_DOES_SUPPORT_CHANNEL_BINDING
+		}
+	case 'n':
+		if s.plus {
+			return ERR_CHANNEL_BINDINGS_DONT_MATCH
+		}
 	}
+	return nil
+}
 
-	storek := s.getHash(s.getClientKey())
+// Check's that received proof matches expected one.
+// Either password should be salted or credentials should be set before
+func (s *scram) checkProof(proof []byte) bool {
+	storek := s.storedKey()
 
 	client_sig := s.getClientSignature(s.authMessage(), storek)
 
 	rck := byteXOR(client_sig, proof)
 
-	return bytes.Equal(s.getHash(rck), storek)
+	return secureEqual(s.getHash(rck), storek)
 }
 
 // Returns slice of bytes used in Server Final message
 func (s *scram) verification() []byte {
-	return s.getServerSignature(s.authMessage(), s.getServerKey())
+	return s.getServerSignature(s.authMessage(), s.serverKey())
 }
 
-// Genarates (if necessary) and returns CNonce as string
-// If CNonce was parsed from Client First message - parsed value will be returned
-func (s *scram) cnonce() []byte {
-	if len(s.client_nonce) == 0 {
-		s.client_nonce = s.gen.GetNonce(CNONCE_BYTES)
+// Returns true if password was salted or credentials were provided
+func (s *scram) hasKeys() bool {
+	return len(s.salted_password) > 0 || (len(s.stored_key) > 0 && len(s.server_key) > 0)
+}
+
+// Returns StoredKey either provided with Credentials or calculated from salted password
+func (s *scram) storedKey() []byte {
+	if len(s.stored_key) == 0 {
+		s.stored_key = s.getHash(s.getClientKey())
+	}
+	return s.stored_key
+}
+
+// Returns ServerKey either provided with Credentials or calculated from salted password
+func (s *scram) serverKey() []byte {
+	if len(s.server_key) == 0 {
+		s.server_key = s.getServerKey()
 	}
+	return s.server_key
+}
+
+// Returns CNonce either generated or parsed from Client First message
+func (s *scram) cnonce() []byte {
 	return s.client_nonce
 }
 
-// Genarates (if necessary) and returns Nonce as string
-// If Nonce was parsed from Server First message - parsed value will be returned
+// Returns Nonce either generated or parsed from Server First message
 func (s *scram) nonce() []byte {
+	return s.server_nonce
+}
+
+// Genarates CNonce if it wasn't generated yet
+func (s *scram) generateCNonce() error {
+	if len(s.client_nonce) == 0 {
+		cnonce, err := s.gen.GetNonce(CNONCE_BYTES)
+		if err != nil {
+			return err
+		}
+		s.client_nonce = cnonce
+	}
+	return nil
+}
+
+// Genarates Nonce by appending server's part to CNonce if it wasn't generated yet
+func (s *scram) generateNonce() error {
 	if len(s.server_nonce) == 0 {
-		s.server_nonce = append(sasl.MakeCopy(s.cnonce()), s.gen.GetNonce(NONCE_BYTES)...)
+		nonce, err := s.gen.GetNonce(NONCE_BYTES)
+		if err != nil {
+			return err
+		}
+		s.server_nonce = append(sasl.MakeCopy(s.cnonce()), nonce...)
 	}
-	return s.server_nonce
+	return nil
 }
 
 // Genarates (if necessary) and returns Iterations count as int
 // If Iterations was parsed from Server First message - parsed value will be returned
 func (s *scram) iterations() int {
 	if s.iterate == 0 {
-		s.iterate = s.gen.GetIterations()
+		s.iterate = max(s.gen.GetIterations(), s.min_iterations)
 	}
 	return s.iterate
 }
 
 func (s *scram) serverFirst() []byte {
-	return sasl.MakeMessage(
+	return sasl.MakeMessage(append([][]byte{
 		makeKeyValue('r', s.nonce()),
-		makeKeyValue('s', sasl.Base64ToBytes(s.Salt())),
+		makeKeyValue('s', sasl.Base64ToBytes(s.salt)),
 		makeKeyValue('i', []byte(strconv.Itoa(s.iterations()))),
-	)
+	}, s.out_extensions...)...)
 }
 
+// Calculates client's proof. Password should be salted before
 func (s *scram) proof() []byte {
 	if len(s.proof_sig) == 0 {
-		if len(s.salted_password) == 0 {
-			panic("Salt password first")
-		}
-
 		clientk := s.getClientKey()
 
 		storek := s.getHash(clientk)
@@ -201,25 +295,31 @@ func (s *scram) getHash(client_key []byte) []byte {
 }
 
 func (s *scram) bareClientFirst() []byte {
-	return sasl.MakeMessage(makeKeyValue('n', s.username), makeKeyValue('r', s.cnonce()))
+	return sasl.MakeMessage(append([][]byte{
+		makeKeyValue('n', prepare(string(s.username))),
+		makeKeyValue('r', s.cnonce()),
+	}, s.out_extensions...)...)
 }
 
 func (s *scram) bindString() []byte {
-	// Client first message should start with 'n', 'y' or 'p'
-	// otherwise it should be treated as invalid
-	bind := []byte{s.binding, ','}
-	if len(s.auth_id) > 0 {
-		bind = append(bind, makeKeyValue('a', s.auth_id)...)
+	return (&GS2Header{Flag: s.binding, CBName: s.cb_name, AuthID: s.auth_id}).Bytes()
+}
+
+// Returns GS2 header followed by channel binding data if binding is used
+func (s *scram) channelBinding() []byte {
+	bind := s.bindString()
+	if s.binding == 'p' {
+		bind = append(bind, s.cb_data...)
 	}
-	return append(bind, ',')
+	return bind
 }
 
 func (s *scram) clientReplyNotProof() []byte {
-	return sasl.MakeMessage(makeKeyValue('c', sasl.Base64ToBytes(s.bindString())), makeKeyValue('r', s.nonce()))
+	return sasl.MakeMessage(makeKeyValue('c', sasl.Base64ToBytes(s.channelBinding())), makeKeyValue('r', s.nonce()))
 }
 
 func (s *scram) authMessage() []byte {
-	return sasl.MakeMessage(s.bareClientFirst(), s.serverFirst(), s.clientReplyNotProof())
+	return sasl.MakeMessage(s.client_first_bare, s.server_first_msg, s.client_final_bare)
 }
 
 func (s *scram) getClientKey() []byte {
@@ -5,6 +5,8 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"testing"
+
+	"github.com/goxmpp/sasl"
 )
 
 const (
@@ -24,20 +26,16 @@ const (
 
 type StdGenerator struct{}
 
-func (g *StdGenerator) GetNonce(ln int) []byte {
+func (g *StdGenerator) GetNonce(ln int) ([]byte, error) {
 	if ln == 21 {
-		return []byte("fyko+d2lbbFgONRv9qkxdawL") // Client's nonce
+		return []byte("fyko+d2lbbFgONRv9qkxdawL"), nil // Client's nonce
 	}
 
-	return []byte("3rfcNHYJY1ZVvWVs7j") // Server's nonce
+	return []byte("3rfcNHYJY1ZVvWVs7j"), nil // Server's nonce
 }
 
-func (g StdGenerator) GetSalt(ln int) []byte {
-	b, err := base64.StdEncoding.DecodeString("QSXCR+Q6sek8bf92")
-	if err != nil {
-		panic(err)
-	}
-	return b
+func (g StdGenerator) GetSalt(ln int) ([]byte, error) {
+	return base64.StdEncoding.DecodeString("QSXCR+Q6sek8bf92")
 }
 
 func (g StdGenerator) GetIterations() int {
@@ -48,21 +46,46 @@ func TestStandardExample(t *testing.T) {
 	mocgen := &StdGenerator{}
 	c := NewClient(sha1.New, mocgen)
 
-	if string(c.First(username)) != std_expect_client_first {
-		t.Log("Expected", std_expect_client_first, "Got", string(c.First(username)))
+	first, err := c.First(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(first) != std_expect_client_first {
+		t.Log("Expected", std_expect_client_first, "Got", string(first))
 		t.Fatal("Client First doesn't match expected Client First")
 	}
 
 	s := NewServer(sha1.New, mocgen)
-	if string(s.First()) != std_expect_server_first {
-		t.Log("Expected", std_expect_server_first, "Got", string(s.First()))
+	if err := s.ParseClientFirst(first); err != nil {
+		t.Fatal("Error parsing Client First:", err)
+	}
+	if _, err := s.SaltPassword([]byte(password)); err != nil {
+		t.Fatal(err)
+	}
+
+	sfirst, err := s.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sfirst) != std_expect_server_first {
+		t.Log("Expected", std_expect_server_first, "Got", string(sfirst))
 		t.Fatal("Server First doesn't match expected Server First")
 	}
 
-	c.SaltPassword([]byte(password))
-	s.SaltPassword([]byte(password))
-	if string(c.Final()) != std_expect_client_final {
-		t.Log("\nExpected", std_expect_client_final, "\nGot     ", string(c.Final()))
+	if err := c.ParseServerFirst(sfirst); err != nil {
+		t.Fatal("Error parsing Server First:", err)
+	}
+	if _, err := c.SaltPassword([]byte(password)); err != nil {
+		t.Fatal(err)
+	}
+
+	cfinal, err := c.Final()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cfinal) != std_expect_client_final {
+		t.Log("\nExpected", std_expect_client_final, "\nGot     ", string(cfinal))
 		t.Fatal("Client Final doesn't match expected Client Final")
 	}
 
@@ -83,21 +106,24 @@ func TestStandardExample(t *testing.T) {
 		t.Fatal("Wrong proof value generated")
 	}
 
-	s.ParseClientFirst(c.First(username))
+	if err := s.CheckClientFinal(cfinal); err != nil {
+		t.Fatal("Proof should be valid", err)
+	}
+
 	if !bytes.Equal(c.proof(), s.proof()) {
 		t.Fatal("Client and Server should generate same proof")
 	}
 
-	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err != nil {
-		t.Fatal("Proof should be valid", err)
+	sfinal, err := s.Final()
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	if string(s.Final()) != std_expect_server_final {
-		t.Log("Expected", std_expect_server_final, "Got", s.Final())
+	if string(sfinal) != std_expect_server_final {
+		t.Log("Expected", std_expect_server_final, "Got", string(sfinal))
 		t.Fatal("Server Final doesn't match expected Server Final")
 	}
 
-	if err := c.CheckServerFinal([]byte(std_expect_server_final)); err != nil {
+	if err := c.CheckServerFinal(sfinal); err != nil {
 		t.Fatal("Verification check failed", err)
 	}
 
@@ -105,6 +131,10 @@ func TestStandardExample(t *testing.T) {
 		t.Log("Epected", std_base64_verification, "Got", base64.StdEncoding.EncodeToString(s.verification()))
 		t.Fatal("Wrong verification value generated")
 	}
+
+	if c.State() != STATE_DONE || s.State() != STATE_DONE {
+		t.Fatal("Both sides should complete authentication, got", c.State(), s.State())
+	}
 }
 
 func TestClientParsing(t *testing.T) {
@@ -131,7 +161,7 @@ func TestClientParsing(t *testing.T) {
 		t.Fatal("Binding doens't match")
 	}
 
-	if err := s.ParseClientFirst([]byte("w=rong")); err == nil {
+	if err := NewServer(sha1.New, mocgen).ParseClientFirst([]byte("w=rong")); err == nil {
 		t.Fatal("Should fail on wrong Client First message")
 	} else {
 		t.Log("Wrong message parsing returned:", err)
@@ -153,3 +183,502 @@ func TestServerParsing(t *testing.T) {
 	}
 
 }
+
+func runExchange(c *Client, s *Server) error {
+	first, err := c.First(username)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ParseClientFirst(first); err != nil {
+		return err
+	}
+
+	if _, err := s.SaltPassword([]byte(password)); err != nil {
+		return err
+	}
+	sfirst, err := s.First()
+	if err != nil {
+		return err
+	}
+	if err := c.ParseServerFirst(sfirst); err != nil {
+		return err
+	}
+
+	if _, err := c.SaltPassword([]byte(password)); err != nil {
+		return err
+	}
+	cfinal, err := c.Final()
+	if err != nil {
+		return err
+	}
+	if err := s.CheckClientFinal(cfinal); err != nil {
+		return err
+	}
+
+	sfinal, err := s.Final()
+	if err != nil {
+		return err
+	}
+	return c.CheckServerFinal(sfinal)
+}
+
+func TestChannelBinding(t *testing.T) {
+	cb := &sasl.ChannelBinding{Type: sasl.TLS_UNIQUE, Data: []byte("tls unique data")}
+	other := &sasl.ChannelBinding{Type: sasl.TLS_EXPORTER, Data: []byte("exporter data")}
+
+	c := NewClientPlus(sha1.New, &StdGenerator{}, cb)
+	s := NewServerPlus(sha1.New, &StdGenerator{}, other, cb)
+	if err := runExchange(c, s); err != nil {
+		t.Fatal("Authentication with channel binding failed:", err)
+	}
+
+	if string(c.bindString()) != "p=tls-unique,," {
+		t.Fatal("Wrong GS2 header in Client First:", string(c.bindString()))
+	}
+
+	bind, err := extractParameter(c.clientReplyNotProof(), 'c')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bind) != base64.StdEncoding.EncodeToString([]byte("p=tls-unique,,tls unique data")) {
+		t.Fatal("Binding data is not included into Client Final:", string(bind))
+	}
+
+	if s.BindingType() != sasl.TLS_UNIQUE {
+		t.Fatal("Wrong binding type selected by server:", s.BindingType())
+	}
+
+	c = NewClientPlus(sha1.New, &StdGenerator{}, &sasl.ChannelBinding{Type: sasl.TLS_UNIQUE, Data: []byte("other data")})
+	s = NewServerPlus(sha1.New, &StdGenerator{}, cb)
+	if err := runExchange(c, s); err == nil {
+		t.Fatal("Authentication with wrong binding data should fail")
+	}
+
+	c = NewClientPlus(sha1.New, &StdGenerator{}, other)
+	s = NewServerPlus(sha1.New, &StdGenerator{}, cb)
+	if err := runExchange(c, s); err == nil {
+		t.Fatal("Authentication with unsupported binding type should fail")
+	}
+}
+
+func TestChannelBindingFlags(t *testing.T) {
+	cb := &sasl.ChannelBinding{Type: sasl.TLS_EXPORTER, Data: []byte("exporter data")}
+
+	// Client supports binding but server pretends it doesn't
+	c := NewClient(sha1.New, &StdGenerator{})
+	c.SetChannelBinding(nil)
+	s := NewServer(sha1.New, &StdGenerator{})
+	s.SetChannelBindings(cb)
+	if err := runExchange(c, s); err == nil {
+		t.Fatal("Downgrade should be detected by server")
+	}
+
+	c = NewClient(sha1.New, &StdGenerator{})
+	c.SetChannelBinding(nil)
+	if err := runExchange(c, NewServer(sha1.New, &StdGenerator{})); err != nil {
+		t.Fatal("Server without binding should accept 'y' flag:", err)
+	}
+
+	if err := runExchange(NewClient(sha1.New, &StdGenerator{}), NewServerPlus(sha1.New, &StdGenerator{}, cb)); err == nil {
+		t.Fatal("-PLUS server should require binding")
+	}
+
+	if err := runExchange(NewClientPlus(sha1.New, &StdGenerator{}, cb), NewServer(sha1.New, &StdGenerator{})); err == nil {
+		t.Fatal("Binding should not be accepted by non -PLUS server")
+	}
+}
+
+func TestSASLprep(t *testing.T) {
+	c := NewClient(sha1.New, &StdGenerator{})
+	first, err := c.First("I\u00ADX=,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != "n,,n=IX=3D=2C,r="+std_cnonce {
+		t.Fatal("Username was not prepared:", string(first))
+	}
+
+	s := NewServer(sha1.New, &StdGenerator{})
+	if err := s.ParseClientFirst(first); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserName() != "IX=," {
+		t.Fatal("Wrong username parsed:", s.UserName())
+	}
+
+	if _, err := NewClient(sha1.New, &StdGenerator{}).First("user\u0007"); err == nil {
+		t.Fatal("Prohibited username should be rejected")
+	}
+
+	if err := NewServer(sha1.New, &StdGenerator{}).ParseClientFirst([]byte("n,,n=user\u0007,r=" + std_cnonce)); err == nil {
+		t.Fatal("Prohibited username should be rejected by server")
+	}
+
+	// Non-ASCII space and decomposed accent should be salted same way as their prepared forms
+	c.SetSaltedPassword(nil, []byte("salt"), 4096)
+	decomposed, err := c.SaltPassword([]byte("pe\u0301ncil\u00A0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	composed, err := c.SaltPassword([]byte("p\u00E9ncil "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decomposed, composed) {
+		t.Fatal("Password should be SASLprepared before salting")
+	}
+
+	if _, err := c.SaltPassword([]byte("pencil\u200E")); err == nil {
+		t.Fatal("Prohibited password should be rejected")
+	}
+}
+
+type passwordStore map[string]string
+
+func (ps passwordStore) Password(username string) (string, error) {
+	if password, ok := ps[username]; ok {
+		return password, nil
+	}
+	return "", ERR_UNKNOWN_USER
+}
+
+type credentialStore map[string]*Credentials
+
+func (cs credentialStore) Credentials(username string) (*Credentials, error) {
+	if creds, ok := cs[username]; ok {
+		return creds, nil
+	}
+	return nil, ERR_UNKNOWN_USER
+}
+
+func TestCredentialStore(t *testing.T) {
+	mocgen := &StdGenerator{}
+	creds, err := NewCredentials(sha1.New, mocgen, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(sha1.New, mocgen)
+	s.SetCredentialStore(credentialStore{username: creds})
+	if err := s.ParseClientFirst([]byte(std_expect_client_first)); err != nil {
+		t.Fatal(err)
+	}
+
+	sfirst, err := s.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sfirst) != std_expect_server_first {
+		t.Fatal("Server First doesn't match expected Server First:", string(sfirst))
+	}
+
+	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err != nil {
+		t.Fatal("Proof should be valid", err)
+	}
+
+	sfinal, err := s.Final()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sfinal) != std_expect_server_final {
+		t.Fatal("Server Final doesn't match expected Server Final:", string(sfinal))
+	}
+
+	if len(s.salted_password) != 0 {
+		t.Fatal("Server should not know salted password")
+	}
+
+	s = NewServer(sha1.New, mocgen)
+	s.SetCredentialStore(credentialStore{username: creds})
+	if err := s.ParseClientFirst([]byte("n,,n=other,r=" + std_cnonce)); err == nil {
+		t.Fatal("Unknown user should be rejected")
+	}
+}
+
+func TestStateMachine(t *testing.T) {
+	mocgen := &StdGenerator{}
+
+	c := NewClient(sha1.New, mocgen)
+	if _, err := c.Final(); err == nil {
+		t.Fatal("Client Final should not be generated before Client First")
+	} else if _, ok := err.(*StateError); !ok {
+		t.Fatal("StateError expected, got", err)
+	}
+
+	first, err := c.First(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.First(username); err == nil {
+		t.Fatal("Client First should not be generated twice")
+	}
+	if _, err := c.Final(); err == nil {
+		t.Fatal("Client Final should not be generated before Server First is parsed")
+	}
+	if err := c.ParseServerFirst([]byte(std_expect_server_first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Final(); err == nil {
+		t.Fatal("Client Final should not be generated before password is salted")
+	}
+
+	s := NewServer(sha1.New, mocgen)
+	if _, err := s.First(); err == nil {
+		t.Fatal("Server First should not be generated before Client First is parsed")
+	}
+	if err := s.ParseClientFirst(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.First(); err == nil {
+		t.Fatal("Server First should not be generated before password is salted")
+	}
+	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err == nil {
+		t.Fatal("Client Final should not be checked before Server First is sent")
+	}
+
+	s.SaltPassword([]byte(password))
+	if _, err := s.First(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Final(); err == nil {
+		t.Fatal("Server Final should not be generated before proof is checked")
+	}
+	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err == nil {
+		t.Fatal("Client Final should not be checked twice")
+	}
+	if _, err := s.Final(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Step([]byte(std_expect_client_first)); err == nil {
+		t.Fatal("Completed session should not be reused")
+	}
+
+	s = NewServer(sha1.New, mocgen)
+	s.ParseClientFirst(first)
+	s.SaltPassword([]byte("wrong"))
+	s.First()
+	if err := s.CheckClientFinal([]byte(std_expect_client_final)); err == nil {
+		t.Fatal("Wrong proof should be rejected")
+	}
+	if s.State() != STATE_FAILED {
+		t.Fatal("Session should fail after wrong proof, got", s.State())
+	}
+	if _, err := s.Final(); err == nil {
+		t.Fatal("Failed session should not be continued")
+	}
+}
+
+func TestServerError(t *testing.T) {
+	mocgen := &StdGenerator{}
+	s := NewServer(sha1.New, mocgen)
+	s.SetPasswordStore(passwordStore{username: "wrong"})
+
+	c := NewClient(sha1.New, mocgen)
+	c.SetCredentials(username, password)
+
+	first, _, _ := c.Step(nil)
+	sfirst, _, err := s.Step(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfinal, _, err := c.Step(sfirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sfinal, _, err := s.Step(cfinal)
+	if err != ERR_INVALID_PROOF {
+		t.Fatal("Proof should be rejected with invalid-proof, got", err)
+	}
+	if string(sfinal) != "e=invalid-proof" {
+		t.Fatal("Wrong Server Final message with error:", string(sfinal))
+	}
+
+	if _, _, err := c.Step(sfinal); err != ERR_INVALID_PROOF {
+		t.Fatal("Client should report server's error, got", err)
+	}
+	if c.State() != STATE_FAILED {
+		t.Fatal("Client should fail after server's error, got", c.State())
+	}
+
+	s = NewServer(sha1.New, mocgen)
+	if final, err := s.FinalError(WrongClientMessage("Something wrong")); err != nil || string(final) != "e=other-error" {
+		t.Fatal("Untyped errors should be reported as other-error, got", string(final), err)
+	}
+
+	s = NewServer(sha1.New, mocgen)
+	s.SetChannelBindings(&sasl.ChannelBinding{Type: sasl.TLS_EXPORTER})
+	err = s.ParseClientFirst([]byte("y,,n=user,r=" + std_cnonce))
+	if final, _ := s.FinalError(err); string(final) != "e=server-does-support-channel-binding" {
+		t.Fatal("Wrong error reported for downgrade:", string(final))
+	}
+}
+
+// Replaces secureEqual with function counting its calls
+func countSecureEqual() (*int, func()) {
+	calls := 0
+	secureEqual = func(left, right []byte) bool {
+		calls++
+		return sasl.SecureEqual(left, right)
+	}
+	return &calls, func() { secureEqual = sasl.SecureEqual }
+}
+
+func TestConstantTimeVerification(t *testing.T) {
+	calls, restore := countSecureEqual()
+	defer restore()
+
+	mocgen := &StdGenerator{}
+	c := NewClient(sha1.New, mocgen)
+	s := NewServer(sha1.New, mocgen)
+
+	first, _ := c.First(username)
+	s.ParseClientFirst(first)
+	s.SaltPassword([]byte(password))
+	sfirst, _ := s.First()
+	c.ParseServerFirst(sfirst)
+	c.SaltPassword([]byte(password))
+	cfinal, _ := c.Final()
+
+	// Binding and proof should be compared in constant time
+	*calls = 0
+	if err := s.CheckClientFinal(cfinal); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 2 {
+		t.Fatal("Client Final should be verified with secureEqual, calls:", *calls)
+	}
+
+	sfinal, _ := s.Final()
+	*calls = 0
+	if err := c.CheckServerFinal(sfinal); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 1 {
+		t.Fatal("Server Final should be verified with secureEqual, calls:", *calls)
+	}
+}
+
+func TestGeneratorFailure(t *testing.T) {
+	c := NewClient(sha1.New, sasl.NewGenerator(bytes.NewReader(nil), 0))
+	if _, err := c.First(username); err == nil {
+		t.Fatal("Generator failure should be reported")
+	}
+	if c.State() != STATE_FAILED {
+		t.Fatal("Session should fail if nonce can't be generated, got", c.State())
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	mocgen := &StdGenerator{}
+
+	c := NewClient(sha1.New, mocgen)
+	c.First(username)
+	if err := c.ParseServerFirst([]byte(std_expect_server_first + ",x=future")); err != nil {
+		t.Fatal("Unknown optional extension should be tolerated:", err)
+	}
+	if v, ok := c.Extension('x'); !ok || string(v) != "future" {
+		t.Fatal("Extension should be available to caller")
+	}
+
+	c = NewClient(sha1.New, mocgen)
+	c.First(username)
+	if err := c.ParseServerFirst([]byte("m=mandatory," + std_expect_server_first)); err == nil {
+		t.Fatal("Mandatory extension should be rejected")
+	} else if _, ok := err.(UnsupportedExtension); !ok {
+		t.Fatal("UnsupportedExtension expected, got", err)
+	}
+
+	s := NewServer(sha1.New, mocgen)
+	err := s.ParseClientFirst([]byte("n,,m=mandatory,n=user,r=" + std_cnonce))
+	if err == nil {
+		t.Fatal("Mandatory extension should be rejected by server")
+	}
+	if final, _ := s.FinalError(err); string(final) != "e=extensions-not-supported" {
+		t.Fatal("Wrong error reported for mandatory extension:", string(final))
+	}
+
+	// Extensions should be handled and included into AuthMessage on both sides
+	var handled []byte
+	c = NewClient(sha1.New, mocgen)
+	c.SetExtension('x', []byte("client"))
+	c.HandleExtension('y', func(value []byte) error {
+		handled = value
+		return nil
+	})
+	s = NewServer(sha1.New, mocgen)
+	s.SetExtension('y', []byte("server"))
+	s.HandleExtension('x', func(value []byte) error {
+		if string(value) != "client" {
+			return WrongClientMessage("Wrong extension value")
+		}
+		return nil
+	})
+	if err := runExchange(c, s); err != nil {
+		t.Fatal("Authentication with extensions failed:", err)
+	}
+	if string(handled) != "server" {
+		t.Fatal("Extension handler was not called")
+	}
+
+	s = NewServer(sha1.New, mocgen)
+	s.HandleExtension('x', func(value []byte) error {
+		return WrongClientMessage("Extension is rejected")
+	})
+	if err := s.ParseClientFirst([]byte("n,,n=user,r=" + std_cnonce + ",x=value")); err == nil {
+		t.Fatal("Error returned by extension handler should abort authentication")
+	}
+}
+
+func TestShortProof(t *testing.T) {
+	mocgen := &StdGenerator{}
+	s := NewServer(sha1.New, mocgen)
+	s.SetPasswordStore(passwordStore{username: password})
+
+	c := NewClient(sha1.New, mocgen)
+	c.SetCredentials(username, password)
+
+	first, _, _ := c.Step(nil)
+	sfirst, _, err := s.Step(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfinal, _, err := c.Step(sfirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	short := append(cfinal[:bytes.LastIndex(cfinal, []byte(",p="))], ",p=AAAA"...)
+	if _, _, err := s.Step(short); err != ERR_INVALID_PROOF {
+		t.Fatal("Short proof should be rejected with invalid-proof, got", err)
+	}
+}
+
+func TestMalformedFinal(t *testing.T) {
+	mocgen := &StdGenerator{}
+	s := NewServer(sha1.New, mocgen)
+	s.SetPasswordStore(passwordStore{username: password})
+
+	c := NewClient(sha1.New, mocgen)
+	c.SetCredentials(username, password)
+
+	first, _, _ := c.Step(nil)
+	sfirst, _, err := s.Step(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Step(sfirst); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.Step([]byte("c=biws,garbage")); err == nil {
+		t.Fatal("Client Final with attribute without value should be rejected")
+	}
+	if _, _, err := c.Step([]byte("garbage")); err == nil {
+		t.Fatal("Server Final without attributes should be rejected")
+	}
+}
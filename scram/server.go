@@ -4,14 +4,104 @@ import (
 	"bytes"
 
 	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/saslprep"
 )
 
 type Server struct {
 	*scram
+
+	store       sasl.PasswordStore // Passwords lookup used by Step
+	credentials CredentialStore    // Credentials lookup used after Client First message is parsed
+	verified    bool               // true when client's proof was verified
 }
 
 func NewServer(h HashConstructor, gen sasl.SaltGenerator) *Server {
-	return &Server{newScram(h, false, gen)}
+	return &Server{scram: newScram(h, false, gen)}
+}
+
+// Creates server for -PLUS variant of mechanism. Client will be required
+// to bind authentication to one of provided channel bindings
+func NewServerPlus(h HashConstructor, gen sasl.SaltGenerator, cbs ...*sasl.ChannelBinding) *Server {
+	s := &Server{scram: newScram(h, true, gen)}
+	s.SetChannelBindings(cbs...)
+	return s
+}
+
+// Sets channel bindings server is able to verify. Server with channel bindings
+// rejects clients which claim that server doesn't support binding
+func (s *Server) SetChannelBindings(cbs ...*sasl.ChannelBinding) {
+	s.bindings = cbs
+}
+
+// Sets passwords store used to authenticate users with Step
+func (s *Server) SetPasswordStore(store sasl.PasswordStore) {
+	s.store = store
+}
+
+// Sets credentials store which will be consulted by ParseClientFirst.
+// Only StoredKey and ServerKey will be used to authenticate user, so salted password is not needed
+func (s *Server) SetCredentialStore(store CredentialStore) {
+	s.credentials = store
+}
+
+// Sets salt, iterations count, StoredKey and ServerKey of the user for further processing
+func (s *Server) SetStoredCredentials(creds *Credentials) {
+	s.salt = creds.Salt
+	s.iterate = creds.Iterations
+	s.stored_key = creds.StoredKey
+	s.server_key = creds.ServerKey
+	s.salted_password = nil
+}
+
+// Implements sasl.ServerMechanism. SetPasswordStore should be called before this method usage.
+// If client's proof is rejected Server Final message with error is returned along with error
+func (s *Server) Step(response []byte) ([]byte, bool, error) {
+	switch s.state {
+	case STATE_INITIAL:
+		if len(response) == 0 {
+			// Client didn't send initial response, ask for it with empty challenge
+			return []byte{}, false, nil
+		}
+		if err := s.ParseClientFirst(response); err != nil {
+			return nil, false, err
+		}
+		if s.credentials == nil {
+			if err := s.fail(s.saltStoredPassword()); err != nil {
+				return nil, false, err
+			}
+		}
+		first, err := s.First()
+		if err != nil {
+			return nil, false, err
+		}
+		return first, false, nil
+	case STATE_FIRST_SENT:
+		if err := s.CheckClientFinal(response); err != nil {
+			final, _ := s.FinalError(err)
+			return final, false, err
+		}
+		final, err := s.Final()
+		if err != nil {
+			return nil, false, err
+		}
+		return final, true, nil
+	}
+
+	return nil, false, s.expectState("Step")
+}
+
+func (s *Server) saltStoredPassword() error {
+	if s.store == nil {
+		return &StateError{Method: "Step", State: s.state, Reason: "Password store is not set"}
+	}
+
+	password, err := s.store.Password(s.UserName())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.SaltPassword([]byte(password))
+	return err
 }
 
 // Returns AuthID for current authentication session.
@@ -28,49 +118,136 @@ func (s *Server) UserName() string {
 	return string(s.username)
 }
 
-// Generates Server First message. SaltPassword should be called before this method usage
-func (s *Server) First() []byte {
-	return s.serverFirst()
+// Generates Server First message. ParseClientFirst and either SaltPassword or
+// SetStoredCredentials should be called before this method usage
+func (s *Server) First() ([]byte, error) {
+	if err := s.expectState("First", STATE_INITIAL); err != nil {
+		return nil, err
+	}
+	if err := s.require("First", len(s.client_nonce) > 0, "Client First should be parsed first"); err != nil {
+		return nil, err
+	}
+	if err := s.require("First", s.hasKeys(), "Salt password or set credentials first"); err != nil {
+		return nil, err
+	}
+
+	if err := s.generateNonce(); err != nil {
+		return nil, s.fail(err)
+	}
+
+	s.server_first_msg = s.serverFirst()
+	s.state = STATE_FIRST_SENT
+	return s.server_first_msg, nil
 }
 
-// Generates Server Final Message
-func (s *Server) Final() []byte {
-	return makeKeyValue('v', sasl.Base64ToBytes(s.verification()))
+// Generates Server Final Message. Client Final message should be checked before this method usage
+func (s *Server) Final() ([]byte, error) {
+	if err := s.expectState("Final", STATE_FIRST_SENT); err != nil {
+		return nil, err
+	}
+	if err := s.require("Final", s.verified, "Client Final should be checked first"); err != nil {
+		return nil, err
+	}
+
+	s.state = STATE_DONE
+	return makeKeyValue('v', sasl.Base64ToBytes(s.verification())), nil
+}
+
+// Generates Server Final message reporting error to client and fails authentication session.
+// Error value is taken from err if it is ServerError, otherwise ERR_OTHER is reported
+func (s *Server) FinalError(err error) ([]byte, error) {
+	if err := s.expectState("FinalError", STATE_INITIAL, STATE_FIRST_SENT, STATE_FAILED); err != nil {
+		return nil, err
+	}
+
+	s.state = STATE_FAILED
+	return makeKeyValue('e', []byte(serverErrorOf(err))), nil
 }
 
 // Parses Client First message and populates Scram's internal fields
-// related to binding, auth_id, username, cnonce
+// related to binding, auth_id, username, cnonce.
+// Unknown attributes are passed to extension handlers and are available with Extension.
+// If credentials store is set user's credentials will be taken from it
 func (s *Server) ParseClientFirst(client_first []byte) error {
-	auth_pref := []byte{'a', '='}
+	if err := s.expectState("ParseClientFirst", STATE_INITIAL); err != nil {
+		return err
+	}
+	if err := s.require("ParseClientFirst", len(s.client_nonce) == 0, "Client First was already parsed"); err != nil {
+		return err
+	}
+
+	return s.fail(s.parseClientFirst(client_first))
+}
 
-	if err := validateMessage(client_first); err != nil {
+func (s *Server) parseClientFirst(client_first []byte) error {
+	header, bare, err := ParseGS2Header(client_first)
+	if err != nil {
 		return err
 	}
+	s.binding, s.cb_name, s.auth_id = header.Flag, header.CBName, header.AuthID
+
+	s.client_first_bare = sasl.MakeCopy(bare)
+	err = sasl.EachToken(bare, ',', func(token []byte) error {
+		k, v, ok := splitAttribute(token)
+		if !ok {
+			return WrongClientMessage("Unknown field")
+		}
 
-	return sasl.EachToken(client_first, ',', func(token []byte) error {
-		switch {
-		case len(token) == 1 && (token[0] == 'n' || token[0] == 'y'):
-			s.binding = token[0]
-		case len(token) == 0 || bytes.HasPrefix(token, auth_pref):
-			if bytes.HasPrefix(token, auth_pref) {
-				_, v := sasl.ExtractKeyValue(token, '=')
-				s.auth_id = deprepare(v)
+		switch k {
+		case 'n':
+			username, err := saslprep.Prepare(string(deprepare(v)))
+			if err != nil {
+				return ERR_INVALID_USERNAME_ENCODING
 			}
-		case bytes.HasPrefix(token, []byte{'n', '='}):
-			_, v := sasl.ExtractKeyValue(token, '=')
-			s.username = deprepare(v)
-		case bytes.HasPrefix(token, []byte{'r', '='}):
-			_, v := sasl.ExtractKeyValue(token, '=')
+			s.username = []byte(username)
+		case 'r':
 			s.client_nonce = v
 		default:
-			return WrongClientMessage("Unknown field")
+			return s.parseExtension(k, v)
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if len(s.username) == 0 || len(s.client_nonce) == 0 {
+		return WrongClientMessage("User name and nonce are required")
+	}
+
+	if err := s.selectBinding(); err != nil {
+		return err
+	}
+
+	if s.credentials != nil {
+		creds, err := s.credentials.Credentials(s.UserName())
+		if err != nil {
+			return err
+		}
+		s.SetStoredCredentials(creds)
+	}
+
+	return nil
 }
 
 // Checks Client Final message checking binding and proof values
 func (s *Server) CheckClientFinal(client_final []byte) error {
+	if err := s.expectState("CheckClientFinal", STATE_FIRST_SENT); err != nil {
+		return err
+	}
+	if err := s.require("CheckClientFinal", !s.verified, "Client Final was already checked"); err != nil {
+		return err
+	}
+
+	if err := s.fail(s.checkClientFinal(client_final)); err != nil {
+		return err
+	}
+
+	s.verified = true
+	return nil
+}
+
+func (s *Server) checkClientFinal(client_final []byte) error {
 	if err := s.checkBinding(client_final); err != nil {
 		return err
 	}
@@ -80,8 +257,37 @@ func (s *Server) CheckClientFinal(client_final []byte) error {
 		return err
 	}
 
-	if !s.checkProof(proof) {
-		return WrongClientMessage("Wrong proof provided")
+	// Proof is the last attribute, everything before it is a part of AuthMessage
+	idx := bytes.LastIndex(client_final, []byte(",p="))
+	if idx < 0 {
+		return WrongClientMessage("Proof should be the last attribute")
+	}
+	s.client_final_bare = sasl.MakeCopy(client_final[:idx])
+
+	err = sasl.EachToken(s.client_final_bare, ',', func(token []byte) error {
+		k, v, ok := splitAttribute(token)
+		if !ok {
+			return WrongClientMessage("Unknown field")
+		}
+
+		switch k {
+		case 'c':
+			// Already checked
+		case 'r':
+			if !bytes.Equal(v, s.nonce()) {
+				return WrongClientMessage("Wrong nonce provided")
+			}
+		default:
+			return s.parseExtension(k, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(proof) != s.cons().Size() || !s.checkProof(proof) {
+		return ERR_INVALID_PROOF
 	}
 
 	return nil
@@ -0,0 +1,43 @@
+package scram
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Attribute of Server First message carrying SCRAM Downgrade Protection hash, see XEP-0474
+const SSDP_ATTRIBUTE = 'd'
+
+// Makes server send hash of mechanisms and channel binding types it advertised in Server First message
+func (s *Server) SetDowngradeProtection(mechanisms, cb_types []string) {
+	s.SetExtension(SSDP_ATTRIBUTE, s.ssdp(mechanisms, cb_types))
+}
+
+// Makes client verify hash sent by server against mechanisms and channel binding
+// types client received. Servers not supporting XEP-0474 don't send the hash,
+// so Server First messages without it are accepted
+func (s *Client) SetDowngradeProtection(mechanisms, cb_types []string) {
+	expected := s.ssdp(mechanisms, cb_types)
+	s.HandleExtension(SSDP_ATTRIBUTE, func(value []byte) error {
+		if !secureEqual(expected, value) {
+			return WrongServerMessage("Advertised mechanisms or channel bindings were changed, possible downgrade attack")
+		}
+		return nil
+	})
+}
+
+// Returns base64 encoded hash of sorted mechanisms and channel binding types
+func (s *scram) ssdp(mechanisms, cb_types []string) []byte {
+	return sasl.Base64ToBytes(s.getHash([]byte(ssdpString(mechanisms, cb_types))))
+}
+
+func ssdpString(mechanisms, cb_types []string) string {
+	mechs := append([]string(nil), mechanisms...)
+	cbs := append([]string(nil), cb_types...)
+	sort.Strings(mechs)
+	sort.Strings(cbs)
+
+	return strings.Join(mechs, ",") + "|" + strings.Join(cbs, ",")
+}
@@ -0,0 +1,77 @@
+package scram
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+)
+
+var (
+	ssdp_mechanisms = []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-1", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "PLAIN"}
+	ssdp_bindings   = []string{"tls-server-end-point", "tls-exporter"}
+)
+
+func TestSSDPString(t *testing.T) {
+	expected := "PLAIN,SCRAM-SHA-1,SCRAM-SHA-1-PLUS,SCRAM-SHA-256,SCRAM-SHA-256-PLUS|tls-exporter,tls-server-end-point"
+	if s := ssdpString(ssdp_mechanisms, ssdp_bindings); s != expected {
+		t.Fatalf("\nExpected %s\nGot      %s", expected, s)
+	}
+	if s := ssdpString([]string{"SCRAM-SHA-1"}, nil); s != "SCRAM-SHA-1|" {
+		t.Fatal("Wrong string without channel bindings:", s)
+	}
+}
+
+func TestSSDP(t *testing.T) {
+	c, s := NewClient(sha256.New, nil), NewServer(sha256.New, nil)
+	c.SetDowngradeProtection([]string{"SCRAM-SHA-1", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "PLAIN"}, ssdp_bindings)
+	s.SetDowngradeProtection(ssdp_mechanisms, ssdp_bindings)
+
+	if err := runExchange(c, s); err != nil {
+		t.Fatal("Downgrade protection should pass", err)
+	}
+	if d, ok := c.Extension(SSDP_ATTRIBUTE); !ok || string(d) != string(s.ssdp(ssdp_mechanisms, ssdp_bindings)) {
+		t.Fatal("Server should send SSDP attribute:", string(d))
+	}
+
+	// Attacker stripped -PLUS mechanisms from list client received
+	c, s = NewClient(sha256.New, nil), NewServer(sha256.New, nil)
+	c.SetDowngradeProtection([]string{"SCRAM-SHA-1", "SCRAM-SHA-256", "PLAIN"}, nil)
+	s.SetDowngradeProtection(ssdp_mechanisms, ssdp_bindings)
+
+	if err := runExchange(c, s); err == nil {
+		t.Fatal("Downgrade should be detected")
+	}
+
+	// Server not supporting XEP-0474
+	c, s = NewClient(sha256.New, nil), NewServer(sha256.New, nil)
+	c.SetDowngradeProtection(ssdp_mechanisms, ssdp_bindings)
+	if err := runExchange(c, s); err != nil {
+		t.Fatal("Server First without SSDP attribute should be accepted", err)
+	}
+}
+
+func TestSSDPMechanism(t *testing.T) {
+	store := passwordStore{username: password}
+
+	c, err := sasl.NewClient("SCRAM-SHA-256", &sasl.Config{Username: username, Password: password, Advertised: []string{"SCRAM-SHA-256"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sasl.NewServer("SCRAM-SHA-256", &sasl.Config{Passwords: store, Advertised: ssdp_mechanisms, AdvertisedBindings: ssdp_bindings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err := c.Step(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chal, _, err := s.Step(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Step(chal); err == nil {
+		t.Fatal("Client should detect downgrade")
+	}
+}
@@ -0,0 +1,81 @@
+package scram
+
+import "fmt"
+
+// State of authentication session
+type State int
+
+const (
+	STATE_INITIAL    State = iota // Nothing was sent yet
+	STATE_FIRST_SENT              // First message was generated
+	STATE_FINAL_SENT              // Client Final message was generated, Server Final is expected
+	STATE_DONE                    // Authentication completed successfully
+	STATE_FAILED                  // Authentication failed
+)
+
+func (st State) String() string {
+	switch st {
+	case STATE_INITIAL:
+		return "initial"
+	case STATE_FIRST_SENT:
+		return "first sent"
+	case STATE_FINAL_SENT:
+		return "final sent"
+	case STATE_DONE:
+		return "done"
+	case STATE_FAILED:
+		return "failed"
+	}
+	return fmt.Sprintf("unknown(%d)", int(st))
+}
+
+// Returned when method is called out of order or authentication session is already finished
+type StateError struct {
+	Method string
+	State  State
+	Reason string
+}
+
+func (se *StateError) Error() string {
+	return fmt.Sprintf("%s can't be called in %s state: %s", se.Method, se.State, se.Reason)
+}
+
+func (se *StateError) MalformedRequest() bool {
+	return true
+}
+
+// Returns current state of authentication session
+func (s *scram) State() State {
+	return s.state
+}
+
+// Checks that session is in one of expected states
+func (s *scram) expectState(method string, states ...State) error {
+	for _, st := range states {
+		if s.state == st {
+			return nil
+		}
+	}
+
+	reason := "message is out of order"
+	if s.state == STATE_DONE || s.state == STATE_FAILED {
+		reason = "authentication session is over"
+	}
+	return &StateError{Method: method, State: s.state, Reason: reason}
+}
+
+// Returns StateError if precondition of method is not satisfied
+func (s *scram) require(method string, cond bool, reason string) error {
+	if !cond {
+		return &StateError{Method: method, State: s.state, Reason: reason}
+	}
+	return nil
+}
+
+// Moves session to failed state if error occurred
+func (s *scram) fail(err error) error {
+	if err != nil {
+		s.state = STATE_FAILED
+	}
+	return err
+}
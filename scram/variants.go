@@ -0,0 +1,73 @@
+package scram
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Member of SCRAM mechanisms family
+type Variant struct {
+	Name          string          // IANA mechanism name without -PLUS suffix
+	Hash          HashConstructor // Hash function used by mechanism
+	MinIterations int             // Minimum iterations count used by server for new salted passwords
+}
+
+var (
+	// SCRAM-SHA-1 described in RFC 5802
+	SHA_1 = &Variant{Name: "SCRAM-SHA-1", Hash: sha1.New, MinIterations: 4096}
+	// SCRAM-SHA-256 described in RFC 7677
+	SHA_256 = &Variant{Name: "SCRAM-SHA-256", Hash: sha256.New, MinIterations: 4096}
+	// SCRAM-SHA-512 described in draft-melnikov-scram-sha-512
+	SHA_512 = &Variant{Name: "SCRAM-SHA-512", Hash: sha512.New, MinIterations: 10000}
+	// SCRAM-SHA3-512 described in draft-melnikov-scram-sha3-512
+	SHA3_512 = &Variant{Name: "SCRAM-SHA3-512", Hash: newSHA3_512, MinIterations: 10000}
+)
+
+// All known variants from the weakest to the strongest
+var Variants = []*Variant{SHA_1, SHA_256, SHA_512, SHA3_512}
+
+func newSHA3_512() hash.Hash {
+	return sha3.New512()
+}
+
+// Returns IANA name of -PLUS variant of mechanism
+func (v *Variant) PlusName() string {
+	return v.Name + "-PLUS"
+}
+
+func (v *Variant) NewClient(gen sasl.SaltGenerator) *Client {
+	return NewClient(v.Hash, gen)
+}
+
+func (v *Variant) NewClientPlus(gen sasl.SaltGenerator, cb *sasl.ChannelBinding) *Client {
+	return NewClientPlus(v.Hash, gen, cb)
+}
+
+// Creates server using at least MinIterations for passwords it salts
+func (v *Variant) NewServer(gen sasl.SaltGenerator) *Server {
+	s := NewServer(v.Hash, gen)
+	s.min_iterations = v.MinIterations
+	return s
+}
+
+func (v *Variant) NewServerPlus(gen sasl.SaltGenerator, cbs ...*sasl.ChannelBinding) *Server {
+	s := NewServerPlus(v.Hash, gen, cbs...)
+	s.min_iterations = v.MinIterations
+	return s
+}
+
+// Calculates credentials which should be stored for user's password using at least MinIterations
+func (v *Variant) NewCredentials(gen sasl.SaltGenerator, password []byte) (*Credentials, error) {
+	s := newScram(v.Hash, false, gen)
+	s.min_iterations = v.MinIterations
+	if _, err := s.SaltPassword(password); err != nil {
+		return nil, err
+	}
+
+	return s.credentials(), nil
+}
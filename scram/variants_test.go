@@ -0,0 +1,100 @@
+package scram
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Example exchange of RFC 7677 section 3
+const (
+	sha256_client_first = "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"
+	sha256_server_first = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	sha256_client_final = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	sha256_server_final = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+type SHA256Generator struct{}
+
+func (g SHA256Generator) GetNonce(ln int) ([]byte, error) {
+	if ln == CNONCE_BYTES {
+		return []byte("rOprNGfwEbeRWgbNEkqO"), nil
+	}
+	return []byte("%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"), nil
+}
+
+func (g SHA256Generator) GetSalt(ln int) ([]byte, error) {
+	return base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+}
+
+func (g SHA256Generator) GetIterations() int {
+	return 4096
+}
+
+func TestSHA256Example(t *testing.T) {
+	c, s := SHA_256.NewClient(SHA256Generator{}), SHA_256.NewServer(SHA256Generator{})
+	c.SetCredentials(username, password)
+	s.SetPasswordStore(passwordStore{username: password})
+
+	expect := func(msg []byte, err error, expected string) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != expected {
+			t.Fatalf("\nExpected %s\nGot      %s", expected, msg)
+		}
+	}
+
+	first, _, err := c.Step(nil)
+	expect(first, err, sha256_client_first)
+
+	sfirst, _, err := s.Step(first)
+	expect(sfirst, err, sha256_server_first)
+
+	final, _, err := c.Step(sfirst)
+	expect(final, err, sha256_client_final)
+
+	sfinal, _, err := s.Step(final)
+	expect(sfinal, err, sha256_server_final)
+
+	if _, done, err := c.Step([]byte(sha256_server_final)); err != nil || !done {
+		t.Fatal("Client should verify Server Final", err)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	names := map[string]bool{}
+	for _, v := range Variants {
+		names[v.Name], names[v.PlusName()] = true, true
+
+		for _, name := range []string{v.Name, v.PlusName()} {
+			if _, err := sasl.Lookup(name); err != nil {
+				t.Fatal(name, "should be registered:", err)
+			}
+		}
+
+		c, s := v.NewClient(nil), v.NewServer(nil)
+		c.SetCredentials(username, password)
+		s.SetPasswordStore(passwordStore{username: password})
+
+		if err := runExchange(c, s); err != nil {
+			t.Fatal(v.Name, "authentication failed:", err)
+		}
+		if s.iterations() < v.MinIterations {
+			t.Fatal(v.Name, "should use at least", v.MinIterations, "iterations, got", s.iterations())
+		}
+
+		creds, err := v.NewCredentials(nil, []byte(password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.Iterations < v.MinIterations {
+			t.Fatal(v.Name, "credentials should use at least", v.MinIterations, "iterations")
+		}
+	}
+
+	if len(names) != 8 || !names["SCRAM-SHA-512-PLUS"] || !names["SCRAM-SHA3-512"] {
+		t.Fatal("Wrong variant names:", names)
+	}
+}
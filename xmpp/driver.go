@@ -0,0 +1,219 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Runs client side of authentication with mechanism over XML stream.
+// Initial response is sent as empty element if mechanism returns nil data
+// and as "=" if it returns empty data. Additional data with success is optional if
+// mechanism has completed with the last challenge. Received failure is returned as *Failure
+func Login(d *xml.Decoder, w io.Writer, mechanism string, c sasl.ClientMechanism) error {
+	resp, done, err := c.Step(nil)
+	if err != nil {
+		return err
+	}
+	if err := send(w, &Auth{Mechanism: mechanism, Payload: EncodePayload(resp, true)}); err != nil {
+		return err
+	}
+
+	for {
+		el, err := readElement(d)
+		if err != nil {
+			return err
+		}
+
+		switch el := el.(type) {
+		case *Challenge:
+			chal, err := DecodePayload(el.Payload)
+			if err == nil {
+				resp, done, err = c.Step(chal)
+			}
+			if err != nil {
+				return abort(d, w, err)
+			}
+			if err := send(w, &Response{Payload: EncodePayload(resp, false)}); err != nil {
+				return err
+			}
+		case *Success:
+			data, err := DecodePayload(el.Payload)
+			if err != nil {
+				return err
+			}
+			if done {
+				// Final data was already received in challenge (RFC 6120 6.4.6)
+				if len(data) != 0 {
+					return UnexpectedElement("success")
+				}
+				return nil
+			}
+			if _, done, err = c.Step(data); err != nil {
+				return err
+			}
+			if !done {
+				return UnexpectedElement("success")
+			}
+			return nil
+		case *Failure:
+			return el
+		default:
+			return abort(d, w, UnexpectedElement(elementName(el)))
+		}
+	}
+}
+
+// Runs server side of authentication over XML stream. Client may choose one of
+// offered mechanisms which will be created from registry using cfg.
+// On error failure is sent to client and error is returned
+func Authenticate(d *xml.Decoder, w io.Writer, mechanisms []string, cfg *sasl.Config) (sasl.ServerMechanism, error) {
+	el, err := readElement(d)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, ok := el.(*Auth)
+	if !ok {
+		return nil, fail(w, UnexpectedElement(elementName(el)))
+	}
+	if !offered(mechanisms, auth.Mechanism) {
+		return nil, fail(w, sasl.UnknownMechanism(auth.Mechanism))
+	}
+
+	m, err := sasl.NewServer(auth.Mechanism, cfg)
+	if err != nil {
+		return nil, fail(w, err)
+	}
+
+	resp, err := DecodePayload(auth.Payload)
+	if err != nil {
+		return nil, fail(w, err)
+	}
+
+	for {
+		chal, done, err := m.Step(resp)
+		if err != nil {
+			return nil, fail(w, err)
+		}
+		if done {
+			return m, send(w, &Success{Payload: EncodePayload(chal, true)})
+		}
+
+		if err := send(w, &Challenge{Payload: EncodePayload(chal, false)}); err != nil {
+			return nil, err
+		}
+
+		el, err := readElement(d)
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := el.(type) {
+		case *Response:
+			if resp, err = DecodePayload(el.Payload); err != nil {
+				return nil, fail(w, err)
+			}
+			if resp == nil {
+				// Empty response element carries zero-length data
+				resp = []byte{}
+			}
+		case *Abort:
+			return nil, fail(w, &Failure{Condition: ABORTED})
+		default:
+			return nil, fail(w, UnexpectedElement(elementName(el)))
+		}
+	}
+}
+
+// Sends failure describing err and returns err
+func fail(w io.Writer, err error) error {
+	if werr := send(w, &Failure{Condition: ConditionOf(err)}); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// Sends abort, waits for server's failure and returns err
+func abort(d *xml.Decoder, w io.Writer, err error) error {
+	if werr := send(w, &Abort{}); werr != nil {
+		return werr
+	}
+	if _, rerr := readElement(d); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func send(w io.Writer, el interface{}) error {
+	return xml.NewEncoder(w).Encode(el)
+}
+
+// Reads next element of SASL namespace
+func readElement(d *xml.Decoder) (interface{}, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != NS {
+			return nil, UnexpectedElement(start.Name.Space + " " + start.Name.Local)
+		}
+
+		var el interface{}
+		switch start.Name.Local {
+		case "auth":
+			el = &Auth{}
+		case "challenge":
+			el = &Challenge{}
+		case "response":
+			el = &Response{}
+		case "success":
+			el = &Success{}
+		case "failure":
+			el = &Failure{}
+		case "abort":
+			el = &Abort{}
+		default:
+			return nil, UnexpectedElement(start.Name.Local)
+		}
+
+		if err := d.DecodeElement(el, &start); err != nil {
+			return nil, err
+		}
+		return el, nil
+	}
+}
+
+func elementName(el interface{}) string {
+	switch el.(type) {
+	case *Auth:
+		return "auth"
+	case *Challenge:
+		return "challenge"
+	case *Response:
+		return "response"
+	case *Success:
+		return "success"
+	case *Failure:
+		return "failure"
+	case *Abort:
+		return "abort"
+	}
+	return "unknown"
+}
+
+func offered(mechanisms []string, name string) bool {
+	for _, m := range mechanisms {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// Package implements SASL negotiation of XMPP described in RFC 6120 section 6
+package xmpp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+)
+
+const NS = "urn:ietf:params:xml:ns:xmpp-sasl"
+
+// Stream feature listing mechanisms offered by server
+type Mechanisms struct {
+	XMLName    xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Mechanisms []string `xml:"mechanism"`
+}
+
+// Initiates authentication with mechanism. Payload is optional initial response
+type Auth struct {
+	XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl auth"`
+	Mechanism string   `xml:"mechanism,attr"`
+	Payload   string   `xml:",chardata"`
+}
+
+type Challenge struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl challenge"`
+	Payload string   `xml:",chardata"`
+}
+
+type Response struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl response"`
+	Payload string   `xml:",chardata"`
+}
+
+// Reports successful authentication. Payload is optional additional data
+type Success struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl success"`
+	Payload string   `xml:",chardata"`
+}
+
+type Abort struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl abort"`
+}
+
+// Defined failure conditions, see RFC 6120 section 6.5
+type Condition string
+
+const (
+	ABORTED                Condition = "aborted"
+	ACCOUNT_DISABLED       Condition = "account-disabled"
+	CREDENTIALS_EXPIRED    Condition = "credentials-expired"
+	ENCRYPTION_REQUIRED    Condition = "encryption-required"
+	INCORRECT_ENCODING     Condition = "incorrect-encoding"
+	INVALID_AUTHZID        Condition = "invalid-authzid"
+	INVALID_MECHANISM      Condition = "invalid-mechanism"
+	MALFORMED_REQUEST      Condition = "malformed-request"
+	MECHANISM_TOO_WEAK     Condition = "mechanism-too-weak"
+	NOT_AUTHORIZED         Condition = "not-authorized"
+	TEMPORARY_AUTH_FAILURE Condition = "temporary-auth-failure"
+)
+
+// Reports failed authentication. Received failure is returned as error by Login
+type Failure struct {
+	Condition Condition
+	Text      string // Optional human readable description
+	Lang      string // Language of Text
+}
+
+func (f *Failure) Error() string {
+	if f.Text != "" {
+		return fmt.Sprintf("Authentication failed: %s (%s)", f.Condition, f.Text)
+	}
+	return fmt.Sprintf("Authentication failed: %s", f.Condition)
+}
+
+type failureText struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type conditionElement struct {
+	XMLName xml.Name
+}
+
+func (f *Failure) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return f.EncodeNamed(e, xml.Name{Space: NS, Local: "failure"})
+}
+
+// Encodes failure as element with provided name. Condition is always in RFC 6120 namespace,
+// text is in namespace of the element. Used by profiles reusing failure, e.g. SASL2
+func (f *Failure) EncodeNamed(e *xml.Encoder, name xml.Name) error {
+	el := struct {
+		XMLName   xml.Name
+		Condition conditionElement
+		Text      *failureText `xml:"text,omitempty"`
+	}{
+		XMLName:   name,
+		Condition: conditionElement{XMLName: xml.Name{Space: NS, Local: string(f.Condition)}},
+	}
+
+	if f.Text != "" {
+		el.Text = &failureText{Lang: f.Lang, Value: f.Text}
+	}
+	return e.Encode(&el)
+}
+
+// Decodes failure element of any namespace taking condition of RFC 6120 namespace
+func (f *Failure) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	el := struct {
+		Conditions []conditionElement `xml:",any"`
+		Text       *failureText       `xml:"text"`
+	}{}
+	if err := d.DecodeElement(&el, &start); err != nil {
+		return err
+	}
+
+	for _, cond := range el.Conditions {
+		if cond.XMLName.Space == NS || cond.XMLName.Space == "" {
+			f.Condition = Condition(cond.XMLName.Local)
+			break
+		}
+	}
+	if el.Text != nil {
+		f.Text, f.Lang = el.Text.Value, el.Text.Lang
+	}
+	return nil
+}
+
+// Encodes payload as base64. Empty data is sent as "=" if equals is true,
+// RFC 6120 requires it for initial response and additional data of success
+func EncodePayload(data []byte, equals bool) string {
+	if len(data) == 0 {
+		if data != nil && equals {
+			return "="
+		}
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Decodes base64 payload. Absent payload is returned as nil, "=" as empty data
+func DecodePayload(payload string) ([]byte, error) {
+	switch payload {
+	case "":
+		return nil, nil
+	case "=":
+		return []byte{}, nil
+	}
+	return base64.StdEncoding.DecodeString(payload)
+}
@@ -0,0 +1,59 @@
+package xmpp
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/goxmpp/sasl"
+)
+
+// Returned when unexpected element is received
+type UnexpectedElement string
+
+func (ue UnexpectedElement) Error() string {
+	return fmt.Sprintf("Unexpected element received: %s", string(ue))
+}
+
+// Returns failure condition describing error returned by mechanism
+func ConditionOf(err error) Condition {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure.Condition
+	}
+
+	var corrupted base64.CorruptInputError
+	if errors.As(err, &corrupted) {
+		return INCORRECT_ENCODING
+	}
+
+	var malformed sasl.MalformedRequest
+	if errors.As(err, &malformed) && malformed.MalformedRequest() {
+		return MALFORMED_REQUEST
+	}
+
+	var encoding sasl.IncorrectEncoding
+	if errors.As(err, &encoding) && encoding.IncorrectEncoding() {
+		return INCORRECT_ENCODING
+	}
+
+	var authz sasl.InvalidAuthzID
+	if errors.As(err, &authz) && authz.InvalidAuthzID() {
+		return INVALID_AUTHZID
+	}
+
+	var temporary sasl.TemporaryFailure
+	if errors.As(err, &temporary) && temporary.TemporaryFailure() {
+		return TEMPORARY_AUTH_FAILURE
+	}
+
+	switch err.(type) {
+	case sasl.UnknownMechanism:
+		return INVALID_MECHANISM
+	case sasl.UnsupportedChannelBinding:
+		return INVALID_MECHANISM
+	case UnexpectedElement:
+		return MALFORMED_REQUEST
+	}
+	return NOT_AUTHORIZED
+}
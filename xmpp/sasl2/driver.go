@@ -0,0 +1,383 @@
+package sasl2
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/xmpp"
+)
+
+// Additional exchange performed after mechanism completes, see XEP-0388 section 2.6.
+// Step has same semantic as sasl.ClientMechanism and sasl.ServerMechanism Step,
+// but data is task specific XML carried in task-data elements
+type Task interface {
+	Step(data []byte) ([]byte, bool, error)
+}
+
+// Options of client side
+type ClientOptions struct {
+	UserAgent *UserAgent
+	Inline    []Element // Inline feature requests, e.g. resource binding
+
+	// Creates task requested by server. Tasks for which error is returned are skipped
+	NewTask func(name string) (Task, error)
+}
+
+// Options of server side
+type ServerOptions struct {
+	Mechanisms []string     // Mechanisms offered to client
+	Config     *sasl.Config // Used to create mechanism chosen by client
+
+	Tasks   []string // Tasks client should complete, one of them is enough
+	NewTask func(name string, m sasl.ServerMechanism) (Task, error)
+
+	// Processes inline feature requests of authenticated client. Returns
+	// authorization identifier and inline feature results sent with success.
+	// If nil AuthID of mechanism is used as authorization identifier
+	Inline func(m sasl.ServerMechanism, auth *Authenticate) (string, []Element, error)
+}
+
+// Returned when server's tasks are not supported by client
+type NoSupportedTask []string
+
+func (nst NoSupportedTask) Error() string {
+	return fmt.Sprintf("No supported task among %v", []string(nst))
+}
+
+// Runs client side of authentication with mechanism over XML stream.
+// Additional data of success is verified by mechanism, e.g. SCRAM Server Final
+// message. Received failure is returned as *Failure
+func Login(d *xml.Decoder, w io.Writer, mechanism string, c sasl.ClientMechanism, opts *ClientOptions) (*Success, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+
+	resp, done, err := c.Step(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Authenticate{Mechanism: mechanism, UserAgent: opts.UserAgent, Inline: opts.Inline}
+	if resp != nil {
+		auth.InitialResponse = xmpp.EncodePayload(resp, true)
+	}
+	if err := send(w, auth); err != nil {
+		return nil, err
+	}
+
+	// Either mechanism or task being performed
+	var current Task = c
+	for {
+		el, err := readElement(d)
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := el.(type) {
+		case *Challenge:
+			chal, err := xmpp.DecodePayload(el.Payload)
+			if err == nil {
+				resp, done, err = c.Step(chal)
+			}
+			if err != nil {
+				return nil, abort(d, w, err)
+			}
+			if err := send(w, &Response{Payload: xmpp.EncodePayload(resp, false)}); err != nil {
+				return nil, err
+			}
+		case *TaskData:
+			var data []byte
+			data, done, err = current.Step(el.Payload)
+			if err != nil {
+				return nil, abort(d, w, err)
+			}
+			if err := send(w, &TaskData{Payload: data}); err != nil {
+				return nil, err
+			}
+		case *Continue:
+			if err := finish(current, done, el.AdditionalData); err != nil {
+				return nil, abort(d, w, err)
+			}
+
+			next, task, err := selectTask(el.Tasks, opts.NewTask)
+			if err != nil {
+				return nil, abort(d, w, err)
+			}
+			current, done = task, false
+			if err := send(w, next); err != nil {
+				return nil, err
+			}
+		case *Success:
+			if err := finish(current, done, el.AdditionalData); err != nil {
+				return nil, err
+			}
+			return el, nil
+		case *Failure:
+			return nil, el
+		default:
+			return nil, abort(d, w, xmpp.UnexpectedElement(el.name()))
+		}
+	}
+}
+
+// Passes additional data to mechanism or task which should complete with it.
+// If it has already completed with the last challenge additional data should be empty
+func finish(t Task, done bool, payload string) error {
+	data, err := xmpp.DecodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	if done {
+		if len(data) != 0 {
+			return xmpp.UnexpectedElement("success")
+		}
+		return nil
+	}
+
+	_, done, err = t.Step(data)
+	if err != nil {
+		return err
+	}
+	if !done {
+		return xmpp.UnexpectedElement("success")
+	}
+	return nil
+}
+
+func selectTask(tasks []string, factory func(string) (Task, error)) (*Next, Task, error) {
+	if factory != nil {
+		for _, name := range tasks {
+			task, err := factory(name)
+			if err != nil {
+				continue
+			}
+
+			data, _, err := task.Step(nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &Next{Task: name, Payload: data}, task, nil
+		}
+	}
+	return nil, nil, NoSupportedTask(tasks)
+}
+
+// Runs server side of authentication over XML stream. On error failure is sent
+// to client and error is returned. Authenticate element is returned along with
+// mechanism, so inline feature requests can be inspected
+func Accept(d *xml.Decoder, w io.Writer, opts *ServerOptions) (sasl.ServerMechanism, *Authenticate, error) {
+	el, err := readElement(d)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auth, ok := el.(*Authenticate)
+	if !ok {
+		return nil, nil, fail(w, xmpp.UnexpectedElement(el.name()))
+	}
+	if !offered(opts.Mechanisms, auth.Mechanism) {
+		return nil, auth, fail(w, sasl.UnknownMechanism(auth.Mechanism))
+	}
+
+	m, err := sasl.NewServer(auth.Mechanism, opts.Config)
+	if err != nil {
+		return nil, auth, fail(w, err)
+	}
+
+	resp, err := xmpp.DecodePayload(auth.InitialResponse)
+	if err != nil {
+		return nil, auth, fail(w, err)
+	}
+
+	// Mechanism completes with additional data sent in continue or success
+	additional, err := exchange(d, w, m, resp, func(chal []byte) interface{} {
+		return &Challenge{Payload: xmpp.EncodePayload(chal, false)}
+	})
+	if err != nil {
+		return nil, auth, err
+	}
+
+	if len(opts.Tasks) > 0 {
+		if additional, err = runTask(d, w, m, opts, additional); err != nil {
+			return nil, auth, err
+		}
+	}
+
+	success := &Success{AdditionalData: xmpp.EncodePayload(additional, true), AuthorizationIdentifier: m.AuthID()}
+	if opts.Inline != nil {
+		if success.AuthorizationIdentifier, success.Inline, err = opts.Inline(m, auth); err != nil {
+			return nil, auth, fail(w, err)
+		}
+	}
+
+	return m, auth, send(w, success)
+}
+
+// Steps through exchange until task completes and returns its additional data
+func exchange(d *xml.Decoder, w io.Writer, t Task, data []byte, wrap func([]byte) interface{}) ([]byte, error) {
+	for {
+		reply, done, err := t.Step(data)
+		if err != nil {
+			return nil, fail(w, err)
+		}
+		if done {
+			return reply, nil
+		}
+
+		if err := send(w, wrap(reply)); err != nil {
+			return nil, err
+		}
+
+		el, err := readElement(d)
+		if err != nil {
+			return nil, err
+		}
+
+		switch el := el.(type) {
+		case *Response:
+			if data, err = xmpp.DecodePayload(el.Payload); err != nil {
+				return nil, fail(w, err)
+			}
+			if data == nil {
+				// Empty response element carries zero-length data
+				data = []byte{}
+			}
+		case *TaskData:
+			data = el.Payload
+		case *Abort:
+			return nil, fail(w, &xmpp.Failure{Condition: xmpp.ABORTED, Text: el.Text})
+		default:
+			return nil, fail(w, xmpp.UnexpectedElement(el.name()))
+		}
+	}
+}
+
+// Sends continue with mechanism's additional data and runs task selected by client
+func runTask(d *xml.Decoder, w io.Writer, m sasl.ServerMechanism, opts *ServerOptions, additional []byte) ([]byte, error) {
+	if err := send(w, &Continue{AdditionalData: xmpp.EncodePayload(additional, true), Tasks: opts.Tasks}); err != nil {
+		return nil, err
+	}
+
+	el, err := readElement(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var next *Next
+	switch el := el.(type) {
+	case *Next:
+		next = el
+	case *Abort:
+		return nil, fail(w, &xmpp.Failure{Condition: xmpp.ABORTED, Text: el.Text})
+	default:
+		return nil, fail(w, xmpp.UnexpectedElement(el.name()))
+	}
+
+	if !offered(opts.Tasks, next.Task) {
+		return nil, fail(w, xmpp.UnexpectedElement("next"))
+	}
+	task, err := opts.NewTask(next.Task, m)
+	if err != nil {
+		return nil, fail(w, err)
+	}
+
+	return exchange(d, w, task, next.Payload, func(data []byte) interface{} {
+		return &TaskData{Payload: data}
+	})
+}
+
+// Sends failure describing err and returns err
+func fail(w io.Writer, err error) error {
+	if werr := send(w, &Failure{xmpp.Failure{Condition: xmpp.ConditionOf(err)}}); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// Sends abort, waits for server's failure and returns err
+func abort(d *xml.Decoder, w io.Writer, err error) error {
+	if werr := send(w, &Abort{}); werr != nil {
+		return werr
+	}
+	if _, rerr := readElement(d); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func send(w io.Writer, el interface{}) error {
+	return xml.NewEncoder(w).Encode(el)
+}
+
+type named interface {
+	name() string
+}
+
+func (*Authenticate) name() string { return "authenticate" }
+func (*Challenge) name() string    { return "challenge" }
+func (*Response) name() string     { return "response" }
+func (*Success) name() string      { return "success" }
+func (*Continue) name() string     { return "continue" }
+func (*Next) name() string         { return "next" }
+func (*TaskData) name() string     { return "task-data" }
+func (*Abort) name() string        { return "abort" }
+func (*Failure) name() string      { return "failure" }
+
+// Reads next element of SASL2 namespace
+func readElement(d *xml.Decoder) (named, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space != NS {
+			return nil, xmpp.UnexpectedElement(start.Name.Space + " " + start.Name.Local)
+		}
+
+		var el named
+		switch start.Name.Local {
+		case "authenticate":
+			el = &Authenticate{}
+		case "challenge":
+			el = &Challenge{}
+		case "response":
+			el = &Response{}
+		case "success":
+			el = &Success{}
+		case "continue":
+			el = &Continue{}
+		case "next":
+			el = &Next{}
+		case "task-data":
+			el = &TaskData{}
+		case "abort":
+			el = &Abort{}
+		case "failure":
+			el = &Failure{}
+		default:
+			return nil, xmpp.UnexpectedElement(start.Name.Local)
+		}
+
+		if err := d.DecodeElement(el, &start); err != nil {
+			return nil, err
+		}
+		return el, nil
+	}
+}
+
+func offered(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
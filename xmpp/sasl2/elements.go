@@ -0,0 +1,97 @@
+// Package implements Extensible SASL Profile described in XEP-0388
+package sasl2
+
+import (
+	"encoding/xml"
+
+	"github.com/goxmpp/sasl/xmpp"
+)
+
+const NS = "urn:xmpp:sasl:2"
+
+// Arbitrary element, e.g. inline feature request or result
+type Element struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+// Stream feature listing mechanisms offered by server and supported inline features
+type Authentication struct {
+	XMLName    xml.Name  `xml:"urn:xmpp:sasl:2 authentication"`
+	Mechanisms []string  `xml:"mechanism"`
+	Inline     *Elements `xml:"inline,omitempty"`
+}
+
+type Elements struct {
+	Elements []Element `xml:",any"`
+}
+
+type UserAgent struct {
+	ID       string `xml:"id,attr,omitempty"`
+	Software string `xml:"software,omitempty"`
+	Device   string `xml:"device,omitempty"`
+}
+
+// Initiates authentication. Elements not defined by XEP-0388 are inline feature requests
+type Authenticate struct {
+	XMLName         xml.Name   `xml:"urn:xmpp:sasl:2 authenticate"`
+	Mechanism       string     `xml:"mechanism,attr"`
+	InitialResponse string     `xml:"initial-response,omitempty"`
+	UserAgent       *UserAgent `xml:"user-agent,omitempty"`
+	Inline          []Element  `xml:",any"`
+}
+
+type Challenge struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 challenge"`
+	Payload string   `xml:",chardata"`
+}
+
+type Response struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 response"`
+	Payload string   `xml:",chardata"`
+}
+
+// Reports successful authentication. Elements not defined by XEP-0388 are inline feature results
+type Success struct {
+	XMLName                 xml.Name  `xml:"urn:xmpp:sasl:2 success"`
+	AdditionalData          string    `xml:"additional-data,omitempty"`
+	AuthorizationIdentifier string    `xml:"authorization-identifier"`
+	Inline                  []Element `xml:",any"`
+}
+
+// Requests client to complete one of tasks before authentication succeeds
+type Continue struct {
+	XMLName        xml.Name `xml:"urn:xmpp:sasl:2 continue"`
+	AdditionalData string   `xml:"additional-data,omitempty"`
+	Tasks          []string `xml:"tasks>task"`
+	Text           string   `xml:"text,omitempty"`
+}
+
+// Selects task client is going to complete. Payload is optional initial task data
+type Next struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 next"`
+	Task    string   `xml:"task,attr"`
+	Payload []byte   `xml:",innerxml"`
+}
+
+// Data exchanged by task. Payload is task specific XML
+type TaskData struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 task-data"`
+	Payload []byte   `xml:",innerxml"`
+}
+
+type Abort struct {
+	XMLName xml.Name `xml:"urn:xmpp:sasl:2 abort"`
+	Text    string   `xml:"text,omitempty"`
+}
+
+// Reports failed authentication with conditions of RFC 6120.
+// Received failure is returned as error by Login
+type Failure struct {
+	xmpp.Failure
+}
+
+func (f *Failure) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return f.EncodeNamed(e, xml.Name{Space: NS, Local: "failure"})
+}
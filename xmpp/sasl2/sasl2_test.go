@@ -0,0 +1,229 @@
+package sasl2
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+	_ "github.com/goxmpp/sasl/digest"
+	_ "github.com/goxmpp/sasl/scram"
+	"github.com/goxmpp/sasl/xmpp"
+)
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	if password, ok := p[username]; ok {
+		return password, nil
+	}
+	return "", errors.New("Unknown user")
+}
+
+// Task completed when client sends expected code
+type codeTask struct {
+	code string
+}
+
+func (t *codeTask) Step(data []byte) ([]byte, bool, error) {
+	if data == nil {
+		// Client side sends code with next element
+		return []byte(t.code), false, nil
+	}
+	if string(data) != "<code>42</code>" {
+		return nil, false, errors.New("Wrong code")
+	}
+	return nil, true, nil
+}
+
+// Client side completes on success
+type clientCodeTask struct {
+	codeTask
+	sent bool
+}
+
+func (t *clientCodeTask) Step(data []byte) ([]byte, bool, error) {
+	if !t.sent {
+		t.sent = true
+		return []byte(t.code), false, nil
+	}
+	return nil, true, nil
+}
+
+func run(t *testing.T, mechanism string, c sasl.ClientMechanism, copts *ClientOptions, sopts *ServerOptions) (*Success, sasl.ServerMechanism, error, error) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	type result struct {
+		m   sasl.ServerMechanism
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		m, _, err := Accept(xml.NewDecoder(sr), sw, sopts)
+		done <- result{m, err}
+		sw.Close()
+	}()
+
+	success, cerr := Login(xml.NewDecoder(cr), cw, mechanism, c, copts)
+	cw.Close()
+
+	res := <-done
+	return success, res.m, cerr, res.err
+}
+
+func scramClient(t *testing.T, password string) sasl.ClientMechanism {
+	c, err := sasl.NewClient("SCRAM-SHA-256", &sasl.Config{Username: "user", Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestInlineFeatures(t *testing.T) {
+	bind := Element{XMLName: xml.Name{Space: "urn:xmpp:bind:0", Local: "bind"}, Inner: []byte("<tag>AwesomeXMPP</tag>")}
+
+	sopts := &ServerOptions{
+		Mechanisms: []string{"SCRAM-SHA-256"},
+		Config:     &sasl.Config{Passwords: passwords{"user": "pencil"}},
+		Inline: func(m sasl.ServerMechanism, auth *Authenticate) (string, []Element, error) {
+			if len(auth.Inline) != 1 || auth.Inline[0].XMLName != bind.XMLName {
+				return "", nil, errors.New("Bind request expected")
+			}
+			if auth.UserAgent == nil || auth.UserAgent.Software != "AwesomeXMPP" {
+				return "", nil, errors.New("User agent expected")
+			}
+			return m.UserName() + "@example.com/AwesomeXMPP.4ebc", []Element{{XMLName: xml.Name{Space: "urn:xmpp:bind:0", Local: "bound"}}}, nil
+		},
+	}
+	copts := &ClientOptions{UserAgent: &UserAgent{ID: "d4565fa7-4d72-4749-b3d3-740edbf87770", Software: "AwesomeXMPP"}, Inline: []Element{bind}}
+
+	success, m, cerr, serr := run(t, "SCRAM-SHA-256", scramClient(t, "pencil"), copts, sopts)
+	if cerr != nil || serr != nil {
+		t.Fatal("Authentication failed:", cerr, serr)
+	}
+
+	if m.UserName() != "user" || success.AuthorizationIdentifier != "user@example.com/AwesomeXMPP.4ebc" {
+		t.Fatal("Wrong identity authenticated:", m.UserName(), success.AuthorizationIdentifier)
+	}
+	data, err := xmpp.DecodePayload(success.AdditionalData)
+	if err != nil || !strings.HasPrefix(string(data), "v=") {
+		t.Fatalf("SCRAM Server Final should be sent as additional data: %q %v", data, err)
+	}
+	if len(success.Inline) != 1 || success.Inline[0].XMLName.Local != "bound" {
+		t.Fatal("Inline feature results should be received:", success.Inline)
+	}
+}
+
+func TestTask(t *testing.T) {
+	sopts := &ServerOptions{
+		Mechanisms: []string{"SCRAM-SHA-256"},
+		Config:     &sasl.Config{Passwords: passwords{"user": "pencil"}},
+		Tasks:      []string{"X-CODE"},
+		NewTask: func(name string, m sasl.ServerMechanism) (Task, error) {
+			return &codeTask{}, nil
+		},
+	}
+
+	for code, ok := range map[string]bool{"<code>42</code>": true, "<code>13</code>": false} {
+		copts := &ClientOptions{NewTask: func(name string) (Task, error) {
+			return &clientCodeTask{codeTask: codeTask{code: code}}, nil
+		}}
+
+		success, _, cerr, serr := run(t, "SCRAM-SHA-256", scramClient(t, "pencil"), copts, sopts)
+		if ok && (cerr != nil || serr != nil || success.AuthorizationIdentifier != "user") {
+			t.Fatal("Task should be completed:", cerr, serr)
+		}
+		if !ok && (cerr == nil || serr == nil) {
+			t.Fatal("Wrong task data should fail authentication")
+		}
+	}
+
+	_, _, cerr, serr := run(t, "SCRAM-SHA-256", scramClient(t, "pencil"), nil, sopts)
+	if _, ok := cerr.(NoSupportedTask); !ok || serr == nil {
+		t.Fatal("Client without tasks support should abort:", cerr, serr)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	sopts := &ServerOptions{Mechanisms: []string{"SCRAM-SHA-256"}, Config: &sasl.Config{Passwords: passwords{"user": "pencil"}}}
+
+	_, _, cerr, serr := run(t, "SCRAM-SHA-256", scramClient(t, "pen"), nil, sopts)
+	if serr == nil {
+		t.Fatal("Authentication with wrong password should fail")
+	}
+	f, ok := cerr.(*Failure)
+	if !ok || f.Condition != xmpp.NOT_AUTHORIZED {
+		t.Fatal("Client should receive not-authorized failure:", cerr)
+	}
+
+	out, err := xml.Marshal(&Failure{xmpp.Failure{Condition: xmpp.ABORTED, Text: "bye"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<failure xmlns="urn:xmpp:sasl:2"><aborted xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></aborted><text>bye</text></failure>`
+	if string(out) != expected {
+		t.Fatalf("\nExpected %s\nGot      %s", expected, out)
+	}
+
+	var parsed Failure
+	if err := xml.Unmarshal(out, &parsed); err != nil || parsed.Condition != xmpp.ABORTED || parsed.Text != "bye" {
+		t.Fatal("Failure changed after parsing:", parsed, err)
+	}
+}
+
+// Server sends rspauth of DIGEST-MD5 in challenge and success without additional data
+func TestSuccessAfterChallenge(t *testing.T) {
+	cfg := &sasl.Config{Username: "user", Password: "pencil", Passwords: passwords{"user": "pencil"}, Service: "xmpp", Host: "example.com"}
+	c, err := sasl.NewClient("DIGEST-MD5", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sasl.NewServer("DIGEST-MD5", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		defer sw.Close()
+		d := xml.NewDecoder(sr)
+
+		var resp []byte
+		for {
+			el, err := readElement(d)
+			if err != nil {
+				errs <- err
+				return
+			}
+			switch el := el.(type) {
+			case *Authenticate:
+				resp, _ = xmpp.DecodePayload(el.InitialResponse)
+			case *Response:
+				if resp, _ = xmpp.DecodePayload(el.Payload); len(resp) == 0 {
+					errs <- send(sw, &Success{AuthorizationIdentifier: "user@example.com"})
+					return
+				}
+			}
+
+			chal, _, err := s.Step(resp)
+			if err != nil {
+				errs <- err
+				return
+			}
+			send(sw, &Challenge{Payload: xmpp.EncodePayload(chal, false)})
+		}
+	}()
+
+	if _, err := Login(xml.NewDecoder(cr), cw, "DIGEST-MD5", c, nil); err != nil {
+		t.Fatal("Success without additional data after final challenge should be accepted:", err)
+	}
+	cw.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/goxmpp/sasl"
+	"github.com/goxmpp/sasl/digest"
+	"github.com/goxmpp/sasl/ht"
+	"github.com/goxmpp/sasl/scram"
+)
+
+type passwords map[string]string
+
+func (p passwords) Password(username string) (string, error) {
+	if password, ok := p[username]; ok {
+		return password, nil
+	}
+	return "", errors.New("Unknown user")
+}
+
+func TestPayload(t *testing.T) {
+	for _, tc := range []struct {
+		el       interface{}
+		expected string
+	}{
+		{&Auth{Mechanism: "EXTERNAL", Payload: EncodePayload([]byte{}, true)}, `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="EXTERNAL">=</auth>`},
+		{&Auth{Mechanism: "CRAM-MD5", Payload: EncodePayload(nil, true)}, `<auth xmlns="urn:ietf:params:xml:ns:xmpp-sasl" mechanism="CRAM-MD5"></auth>`},
+		{&Response{Payload: EncodePayload([]byte{}, false)}, `<response xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></response>`},
+		{&Success{Payload: EncodePayload([]byte("v=abc"), true)}, `<success xmlns="urn:ietf:params:xml:ns:xmpp-sasl">dj1hYmM=</success>`},
+		{&Failure{Condition: NOT_AUTHORIZED}, `<failure xmlns="urn:ietf:params:xml:ns:xmpp-sasl"><not-authorized xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></not-authorized></failure>`},
+	} {
+		out, err := xml.Marshal(tc.el)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tc.expected {
+			t.Fatalf("\nExpected %s\nGot      %s", tc.expected, out)
+		}
+	}
+
+	if data, err := DecodePayload("="); err != nil || data == nil || len(data) != 0 {
+		t.Fatal("'=' should be decoded as empty data", err)
+	}
+	if data, err := DecodePayload(""); err != nil || data != nil {
+		t.Fatal("Absent payload should be decoded as nil", err)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	in := `<failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><account-disabled/><text xml:lang='en'>Call 212-555-1212 for help.</text></failure>`
+
+	f := &Failure{}
+	if err := xml.Unmarshal([]byte(in), f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Condition != ACCOUNT_DISABLED || f.Text != "Call 212-555-1212 for help." || f.Lang != "en" {
+		t.Fatalf("Wrong failure parsed: %+v", f)
+	}
+
+	out, err := xml.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := &Failure{}
+	if err := xml.Unmarshal(out, back); err != nil {
+		t.Fatal(err)
+	}
+	if *back != *f {
+		t.Fatalf("Failure should survive round trip: %s", out)
+	}
+}
+
+// Runs client and server drivers over in-memory pipes
+func run(t *testing.T, mechanism string, offered []string, ccfg, scfg *sasl.Config) (sasl.ServerMechanism, error, error) {
+	c, err := sasl.NewClient(mechanism, ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	type result struct {
+		m   sasl.ServerMechanism
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		m, err := Authenticate(xml.NewDecoder(sr), sw, offered, scfg)
+		done <- result{m, err}
+		sw.Close()
+	}()
+
+	cerr := Login(xml.NewDecoder(cr), cw, mechanism, c)
+	cw.Close()
+
+	res := <-done
+	return res.m, cerr, res.err
+}
+
+func TestDriver(t *testing.T) {
+	offered := sasl.Mechanisms()
+	store := passwords{"user": "pencil"}
+
+	for _, name := range []string{"SCRAM-SHA-1", "SCRAM-SHA-256", "DIGEST-MD5"} {
+		m, cerr, serr := run(t, name, offered,
+			&sasl.Config{Username: "user", Password: "pencil", Service: "xmpp", Host: "example.com"},
+			&sasl.Config{Passwords: store, Host: "example.com"})
+		if cerr != nil || serr != nil {
+			t.Fatal(name, "authentication failed:", cerr, serr)
+		}
+		if m.UserName() != "user" {
+			t.Fatal(name, "wrong user authenticated:", m.UserName())
+		}
+
+		_, cerr, serr = run(t, name, offered,
+			&sasl.Config{Username: "user", Password: "pen", Service: "xmpp", Host: "example.com"},
+			&sasl.Config{Passwords: store, Host: "example.com"})
+		if serr == nil || cerr == nil {
+			t.Fatal(name, "authentication with wrong password should fail")
+		}
+		var f *Failure
+		if !errors.As(cerr, &f) || f.Condition != NOT_AUTHORIZED {
+			t.Fatal(name, "client should receive not-authorized failure:", cerr)
+		}
+	}
+}
+
+func TestInvalidMechanism(t *testing.T) {
+	_, cerr, serr := run(t, "SCRAM-SHA-1", []string{"SCRAM-SHA-256"},
+		&sasl.Config{Username: "user", Password: "pencil"}, &sasl.Config{})
+	if serr == nil {
+		t.Fatal("Not offered mechanism should be rejected")
+	}
+	var f *Failure
+	if !errors.As(cerr, &f) || f.Condition != INVALID_MECHANISM {
+		t.Fatal("Client should receive invalid-mechanism failure:", cerr)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	in := `<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='SCRAM-SHA-1'>` +
+		`biwsbj11c2VyLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdM</auth>` +
+		`<abort xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>`
+
+	var out bytes.Buffer
+	_, err := Authenticate(xml.NewDecoder(strings.NewReader(in)), &out, []string{"SCRAM-SHA-1"}, &sasl.Config{Passwords: passwords{"user": "pencil"}})
+	if ConditionOf(err) != ABORTED {
+		t.Fatal("Authentication should be aborted:", err)
+	}
+	if !strings.HasSuffix(out.String(), `<aborted xmlns="urn:ietf:params:xml:ns:xmpp-sasl"></aborted></failure>`) {
+		t.Fatal("Server should send aborted failure:", out.String())
+	}
+}
+
+func TestConditionOf(t *testing.T) {
+	_, err := DecodePayload("!!!")
+	if ConditionOf(err) != INCORRECT_ENCODING {
+		t.Fatal("Wrong base64 should be reported as incorrect-encoding")
+	}
+	if ConditionOf(sasl.UnknownMechanism("X")) != INVALID_MECHANISM {
+		t.Fatal("Unknown mechanism should be reported as invalid-mechanism")
+	}
+	if ConditionOf(ht.WrongClientMessage("No counter")) != MALFORMED_REQUEST {
+		t.Fatal("Malformed HT message should be reported as malformed-request")
+	}
+	s, _ := digest.NewServer(&digest.Options{})
+	if ConditionOf(s.ParseResponse([]byte("username"))) != MALFORMED_REQUEST {
+		t.Fatal("Malformed DIGEST-MD5 response should be reported as malformed-request")
+	}
+	if ConditionOf(scram.ERR_NO_RESOURCES) != TEMPORARY_AUTH_FAILURE {
+		t.Fatal("SCRAM no-resources should be reported as temporary-auth-failure")
+	}
+	if ConditionOf(errors.New("Unknown user")) != NOT_AUTHORIZED {
+		t.Fatal("Other errors should be reported as not-authorized")
+	}
+}
+
+// Server sends rspauth of DIGEST-MD5 in challenge and empty success after client's empty response
+func TestSuccessAfterChallenge(t *testing.T) {
+	cfg := &sasl.Config{Username: "user", Password: "pencil", Passwords: passwords{"user": "pencil"}, Service: "xmpp", Host: "example.com"}
+	c, err := sasl.NewClient("DIGEST-MD5", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := sasl.NewServer("DIGEST-MD5", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		defer sw.Close()
+		d := xml.NewDecoder(sr)
+
+		var resp []byte
+		for {
+			el, err := readElement(d)
+			if err != nil {
+				errs <- err
+				return
+			}
+			switch el := el.(type) {
+			case *Auth:
+				resp, _ = DecodePayload(el.Payload)
+			case *Response:
+				if resp, _ = DecodePayload(el.Payload); len(resp) == 0 {
+					errs <- send(sw, &Success{})
+					return
+				}
+			}
+
+			chal, _, err := s.Step(resp)
+			if err != nil {
+				errs <- err
+				return
+			}
+			send(sw, &Challenge{Payload: EncodePayload(chal, false)})
+		}
+	}()
+
+	if err := Login(xml.NewDecoder(cr), cw, "DIGEST-MD5", c); err != nil {
+		t.Fatal("Empty success after final challenge should be accepted:", err)
+	}
+	cw.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}